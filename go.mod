@@ -7,6 +7,7 @@ require (
 	github.com/adrg/xdg v0.4.0
 	github.com/fatih/color v1.13.0
 	github.com/gizak/termui/v3 v3.1.0
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/jawher/mow.cli v1.2.0
 	github.com/mattn/go-colorable v0.1.12 // indirect
 	github.com/mattn/go-runewidth v0.0.13 // indirect
@@ -4,11 +4,14 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"os"
+	"os/exec"
 	"path"
-	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/adrg/xdg"
@@ -56,6 +59,9 @@ func start(config *pomo.Config) func(*cli.Cmd) {
 			pomodoros = cmd.IntOpt("p pomodoros", 4, "number of pomodoros")
 			message   = cmd.StringArg("MESSAGE", "", "descriptive name of the given task")
 			tags      = cmd.StringsOpt("t tag", []string{}, "tags associated with this task")
+			project   = cmd.StringOpt("project", "", "project the task belongs to")
+			parent    = cmd.IntOpt("parent", 0, "ID of the task this is a sub-task of")
+			detach    = cmd.BoolOpt("detach", false, "run the session in the background")
 		)
 		cmd.Action = func() {
 			parsed, err := time.ParseDuration(*duration)
@@ -68,23 +74,23 @@ func start(config *pomo.Config) func(*cli.Cmd) {
 				Tags:       *tags,
 				NPomodoros: *pomodoros,
 				Duration:   parsed,
+				Project:    *project,
+				ParentID:   *parent,
 			}
+			maybe(task.Validate())
 			maybe(db.With(func(tx *sql.Tx) error {
 				id, err := db.CreateTask(tx, *task)
 				if err != nil {
 					return err
 				}
-				task.ID = id
-				return nil
+				task, err = db.ReadTask(tx, id)
+				return err
 			}))
-			runner, err := pomo.NewTaskRunner(task, config)
-			maybe(err)
-			server, err := pomo.NewServer(runner, config)
-			maybe(err)
-			server.Start()
-			defer server.Stop()
-			runner.Start()
-			pomo.StartUI(runner)
+			if *detach {
+				startDetached(config, task.ID, false)
+				return
+			}
+			runSession(config, task, nil)
 		}
 	}
 }
@@ -97,6 +103,8 @@ func create(config *pomo.Config) func(*cli.Cmd) {
 			pomodoros = cmd.IntOpt("p pomodoros", 4, "number of pomodoros")
 			message   = cmd.StringArg("MESSAGE", "", "descriptive name of the given task")
 			tags      = cmd.StringsOpt("t tag", []string{}, "tags associated with this task")
+			project   = cmd.StringOpt("project", "", "project the task belongs to")
+			parent    = cmd.IntOpt("parent", 0, "ID of the task this is a sub-task of")
 		)
 		cmd.Action = func() {
 			parsed, err := time.ParseDuration(*duration)
@@ -109,7 +117,10 @@ func create(config *pomo.Config) func(*cli.Cmd) {
 				Tags:       *tags,
 				NPomodoros: *pomodoros,
 				Duration:   parsed,
+				Project:    *project,
+				ParentID:   *parent,
 			}
+			maybe(task.Validate())
 			maybe(db.With(func(tx *sql.Tx) error {
 				taskId, err := db.CreateTask(tx, *task)
 				if err != nil {
@@ -122,11 +133,186 @@ func create(config *pomo.Config) func(*cli.Cmd) {
 	}
 }
 
-func begin(config *pomo.Config) func(*cli.Cmd) {
+// editableTask is the JSON opened in $EDITOR by pomo edit
+type editableTask struct {
+	Message    string   `json:"message"`
+	Tags       []string `json:"tags"`
+	NPomodoros int      `json:"n_pomodoros"`
+	Duration   string   `json:"duration"`
+}
+
+// editTask opens the task as JSON in the users
+// editor and applies the changes that were saved.
+func editTask(task *pomo.Task) error {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	tags := task.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	raw, err := json.MarshalIndent(editableTask{
+		Message:    task.Message,
+		Tags:       tags,
+		NPomodoros: task.NPomodoros,
+		Duration:   task.Duration.String(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "pomo-task-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(append(raw, '\n'))
+	f.Close()
+	if err != nil {
+		return err
+	}
+	// EDITOR may contain arguments such as "code --wait"
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], f.Name())...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("running %s: %s", editor, err)
+	}
+	raw, err = ioutil.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+	edited := editableTask{}
+	err = json.Unmarshal(raw, &edited)
+	if err != nil {
+		return fmt.Errorf("invalid task: %s", err)
+	}
+	duration, err := time.ParseDuration(edited.Duration)
+	if err != nil {
+		return fmt.Errorf("invalid task: %s", err)
+	}
+	task.Message = edited.Message
+	task.Tags = edited.Tags
+	task.NPomodoros = edited.NPomodoros
+	task.Duration = duration
+	return nil
+}
+
+func edit(config *pomo.Config) func(*cli.Cmd) {
 	return func(cmd *cli.Cmd) {
 		cmd.Spec = "[OPTIONS] TASK_ID"
+		cmd.LongDesc = `
+edit a stored task without changing its history
+
+Examples:
+
+# rename a task and give it one more pomodoro
+pomo edit -m "write more codes" -p 5 1
+# replace every tag
+pomo edit -t work -t code 1
+pomo edit --add-tag code --remove-tag home 1
+# edit the task as JSON in $EDITOR
+pomo edit -e 1
+# move the task and its sub-tasks to another project
+pomo edit --project website 1
+# make the task a sub-task of task 2 or a top level task again
+pomo edit --parent 2 1
+pomo edit --parent 0 1
+`
 		var (
-			taskId = cmd.IntArg("TASK_ID", -1, "ID of Pomodoro to begin")
+			tagsSet    bool
+			projectSet bool
+			parentSet  bool
+			taskID     = cmd.IntArg("TASK_ID", -1, "ID of the task to edit")
+			message    = cmd.StringOpt("m message", "", "descriptive name of the task")
+			pomodoros  = cmd.IntOpt("p pomodoros", 0, "number of pomodoros")
+			duration   = cmd.StringOpt("d duration", "", "duration of each stent")
+			addTags    = cmd.StringsOpt("add-tag", []string{}, "add a tag to the task")
+			removeTags = cmd.StringsOpt("remove-tag", []string{}, "remove a tag from the task")
+			useEditor  = cmd.BoolOpt("e editor", false, "edit the task as JSON in $EDITOR")
+			tags       = cmd.Strings(cli.StringsOpt{
+				Name:      "t tag",
+				Value:     []string{},
+				Desc:      "replace the tags of the task",
+				SetByUser: &tagsSet,
+			})
+			project = cmd.String(cli.StringOpt{
+				Name:      "project",
+				Desc:      "move the task and its sub-tasks to this project",
+				SetByUser: &projectSet,
+			})
+			parent = cmd.Int(cli.IntOpt{
+				Name:      "parent",
+				Desc:      "ID of the task this is a sub-task of, 0 for none",
+				SetByUser: &parentSet,
+			})
+		)
+		cmd.Action = func() {
+			db, err := pomo.NewStore(config.DBPath)
+			maybe(err)
+			defer db.Close()
+			var task *pomo.Task
+			maybe(db.With(func(tx *sql.Tx) error {
+				task, err = db.ReadTask(tx, *taskID)
+				return err
+			}))
+			if *message != "" {
+				task.Message = *message
+			}
+			if *pomodoros != 0 {
+				task.NPomodoros = *pomodoros
+			}
+			if *duration != "" {
+				parsed, err := time.ParseDuration(*duration)
+				maybe(err)
+				task.Duration = parsed
+			}
+			if tagsSet {
+				task.Tags = *tags
+			}
+			task.Tags = append(task.Tags, *addTags...)
+			for _, remove := range *removeTags {
+				kept := []string{}
+				for _, tag := range task.Tags {
+					if tag != remove {
+						kept = append(kept, tag)
+					}
+				}
+				task.Tags = kept
+			}
+			if *useEditor {
+				maybe(editTask(task))
+			}
+			maybe(task.Validate())
+			maybe(db.With(func(tx *sql.Tx) error {
+				err := db.UpdateTask(tx, *task)
+				if err != nil {
+					return err
+				}
+				if parentSet {
+					err = db.SetTaskParent(tx, task.ID, *parent)
+					if err != nil {
+						return err
+					}
+				}
+				if projectSet {
+					return db.MoveTask(tx, task.ID, *project)
+				}
+				return nil
+			}))
+		}
+	}
+}
+
+func begin(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS] [TASK_ID]"
+		var (
+			taskId = cmd.IntArg("TASK_ID", -1, "ID of Pomodoro to begin, defaults to the oldest todo task")
+			detach = cmd.BoolOpt("detach", false, "run the session in the background")
 		)
 
 		cmd.Action = func() {
@@ -135,6 +321,21 @@ func begin(config *pomo.Config) func(*cli.Cmd) {
 			defer db.Close()
 			var task *pomo.Task
 			maybe(db.With(func(tx *sql.Tx) error {
+				if *taskId == -1 {
+					// take the next task from the backlog
+					tasks, err := db.QueryTasks(tx, pomo.TaskQuery{
+						States: []pomo.TaskState{pomo.TaskTodo},
+						Limit:  1,
+					})
+					if err != nil {
+						return err
+					}
+					if len(tasks) == 0 {
+						return fmt.Errorf("there are no todo tasks to begin")
+					}
+					task = tasks[0]
+					return nil
+				}
 				read, err := db.ReadTask(tx, *taskId)
 				if err != nil {
 					return err
@@ -142,63 +343,301 @@ func begin(config *pomo.Config) func(*cli.Cmd) {
 				task = read
 				return nil
 			}))
-			runner, err := pomo.NewTaskRunner(task, config)
+			if *detach {
+				startDetached(config, task.ID, false)
+				return
+			}
+			runSession(config, task, nil)
+		}
+	}
+}
+
+// runSession runs the task session in the foreground with the
+// terminal user interface, it is restored from the checkpoint
+// unless that is nil.
+func runSession(config *pomo.Config, task *pomo.Task, checkpoint *pomo.Checkpoint) {
+	// keep logged failures from drawing over the user interface
+	logFile, err := os.OpenFile(
+		path.Join(config.BasePath, "pomo.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	maybe(err)
+	defer logFile.Close()
+	log.SetOutput(logFile)
+	defer log.SetOutput(os.Stderr)
+	runner, err := pomo.NewTaskRunner(task, config, pomo.SystemClock)
+	maybe(err)
+	if checkpoint != nil {
+		maybe(runner.Restore(checkpoint))
+	}
+	server, err := pomo.NewServer(runner, config)
+	maybe(err)
+	server.Start()
+	defer server.Stop()
+	defer pomo.ServeAPI(config)()
+	runner.Start()
+	pomo.StartUI(runner)
+}
+
+// startDetached runs the task session in a
+// background daemon process.
+func startDetached(config *pomo.Config, taskID int, restore bool) {
+	pid, err := pomo.Detach(config, taskID, restore)
+	maybe(err)
+	// wait for the daemon to begin serving
+	for i := 0; i < 20; i++ {
+		client, err := pomo.NewClient(config.SocketPath)
+		if err == nil {
+			client.Close()
+			fmt.Printf("started pomo daemon with pid %d\n", pid)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	maybe(fmt.Errorf("pomo daemon %d did not start, see %s", pid, path.Join(config.BasePath, "daemon.log")))
+}
+
+func browse(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS]"
+		cmd.LongDesc = `
+browse the task history in a full screen table
+
+Press [/] to filter tasks by the words in their message, words of the
+form tag:NAME, project:NAME or state:STATE filter by those fields.
+Archived tasks are only shown when filtering by state:archived.
+
+Keys:
+
+j, k, arrows   select a task
+/              edit the filter, [Enter] or [Esc] to return to the table
+b, Enter       begin the selected task
+e              edit the selected task as JSON in $EDITOR
+a              archive the selected task
+d              delete the selected task
+q              quit
+`
+		cmd.Action = func() {
+			db, err := pomo.NewStore(config.DBPath)
 			maybe(err)
-			server, err := pomo.NewServer(runner, config)
+			defer db.Close()
+			browser := pomo.NewBrowser(config, db)
+			for {
+				action, task, err := browser.Run()
+				maybe(err)
+				switch action {
+				case pomo.BrowserEdit:
+					maybe(editTask(task))
+					maybe(task.Validate())
+					maybe(db.With(func(tx *sql.Tx) error {
+						return db.UpdateTask(tx, *task)
+					}))
+				case pomo.BrowserBegin:
+					runSession(config, task, nil)
+					return
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func daemon(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS] TASK_ID"
+		cmd.LongDesc = `
+run a task session in the foreground without a user interface,
+the session is controlled through the status socket and stopped
+by sending SIGTERM.
+`
+		var (
+			taskId  = cmd.IntArg("TASK_ID", -1, "ID of Pomodoro to begin")
+			restore = cmd.BoolOpt("restore", false, "continue the session from the last checkpoint")
+		)
+		cmd.Action = func() {
+			db, err := pomo.NewStore(config.DBPath)
 			maybe(err)
-			server.Start()
-			defer server.Stop()
-			runner.Start()
-			pomo.StartUI(runner)
+			var (
+				task       *pomo.Task
+				checkpoint *pomo.Checkpoint
+			)
+			maybe(db.With(func(tx *sql.Tx) error {
+				read, err := db.ReadTask(tx, *taskId)
+				if err != nil {
+					return err
+				}
+				task = read
+				if *restore {
+					checkpoint, err = db.ReadCheckpoint(tx)
+					if err != nil {
+						return err
+					}
+					if checkpoint == nil {
+						return fmt.Errorf("there is no session to restore")
+					}
+				}
+				return nil
+			}))
+			db.Close()
+			runner, err := pomo.NewTaskRunner(task, config, pomo.SystemClock)
+			maybe(err)
+			if checkpoint != nil {
+				maybe(runner.Restore(checkpoint))
+			}
+			maybe(pomo.RunDaemon(runner, config))
 		}
 	}
 }
 
-func initialize(config *pomo.Config) func(*cli.Cmd) {
+func resume(config *pomo.Config) func(*cli.Cmd) {
 	return func(cmd *cli.Cmd) {
 		cmd.Spec = "[OPTIONS]"
+		cmd.LongDesc = `
+resume the paused pomodoro of a running session, if no session is
+running the last session is restored from its checkpoint with the
+time that was remaining when pomo exited.
+`
+		var (
+			detach = cmd.BoolOpt("detach", false, "run the restored session in the background")
+		)
 		cmd.Action = func() {
+			client, err := pomo.NewClient(config.SocketPath)
+			if err == nil {
+				defer client.Close()
+				_, err = client.Resume()
+				maybe(err)
+				return
+			}
 			db, err := pomo.NewStore(config.DBPath)
 			maybe(err)
 			defer db.Close()
+			var (
+				task       *pomo.Task
+				checkpoint *pomo.Checkpoint
+			)
+			maybe(db.With(func(tx *sql.Tx) error {
+				checkpoint, err = db.ReadCheckpoint(tx)
+				if err != nil {
+					return err
+				}
+				if checkpoint == nil {
+					return fmt.Errorf("there is no session to resume")
+				}
+				task, err = db.ReadTask(tx, checkpoint.TaskID)
+				return err
+			}))
+			if *detach {
+				startDetached(config, task.ID, true)
+				return
+			}
+			runSession(config, task, checkpoint)
+		}
+	}
+}
+
+func initialize(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS]"
+		cmd.Action = func() {
+			db, err := pomo.OpenStore(config.DBPath)
+			maybe(err)
+			defer db.Close()
 			maybe(pomo.InitDB(db))
 		}
 	}
 }
 
+func migrate(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS]"
+		cmd.Action = func() {
+			db, err := pomo.OpenStore(config.DBPath)
+			maybe(err)
+			defer db.Close()
+			from, to, err := pomo.MigrateDB(db)
+			maybe(err)
+			if from == to {
+				fmt.Printf("database is up to date (version %d)\n", to)
+				return
+			}
+			fmt.Printf("migrated database from version %d to %d\n", from, to)
+		}
+	}
+}
+
 func list(config *pomo.Config) func(*cli.Cmd) {
 	return func(cmd *cli.Cmd) {
 		cmd.Spec = "[OPTIONS]"
 		var (
 			asJSON   = cmd.BoolOpt("json", false, "output task history as JSON")
 			assend   = cmd.BoolOpt("assend", false, "sort tasks assending in age")
-			all      = cmd.BoolOpt("a all", true, "output all tasks")
+			all      = cmd.BoolOpt("a all", false, "output all tasks including archived ones ignoring any date range")
 			limit    = cmd.IntOpt("n limit", 0, "limit the number of results by n")
-			duration = cmd.StringOpt("d duration", "24h", "show tasks within this duration")
+			offset   = cmd.IntOpt("offset", 0, "skip the first n results")
+			duration = cmd.StringOpt("d duration", "", "show tasks within this duration")
+			since    = cmd.StringOpt("since", "", "show tasks with pomodoros started on or after this date")
+			until    = cmd.StringOpt("until", "", "show tasks with pomodoros started before this date")
+			tags     = cmd.StringsOpt("t tag", []string{}, "show tasks with any of these tags")
+			allTags  = cmd.BoolOpt("all-tags", false, "show tasks with all of the given tags")
+			grep     = cmd.StringOpt("g grep", "", "show tasks whose message contains this text")
+			progress = cmd.StringOpt("progress", "", "show tasks that are not-started, incomplete or complete")
+			states   = cmd.StringsOpt("s state", []string{}, "show tasks in this state, todo, active, done or archived")
+			sortBy   = cmd.StringOpt("sort", "id", "sort tasks by id, start or message")
+			project  = cmd.StringOpt("project", "", "show tasks in this project")
+			subtree  = cmd.IntOpt("subtree", 0, "show this task and all of its sub-tasks")
+			tree     = cmd.BoolOpt("tree", false, "show sub-tasks below their parent with rolled up pomodoros")
 		)
 		cmd.Action = func() {
-			duration, err := time.ParseDuration(*duration)
-			maybe(err)
+			query := pomo.TaskQuery{
+				Tags:       *tags,
+				AllTags:    *allTags,
+				Grep:       *grep,
+				Progress:   pomo.TaskProgress(*progress),
+				Limit:      *limit,
+				Offset:     *offset,
+				Sort:       pomo.TaskSort(*sortBy),
+				Descending: *assend,
+				Project:    *project,
+				Subtree:    *subtree,
+			}
+			for _, state := range *states {
+				query.States = append(query.States, pomo.TaskState(state))
+			}
+			if !*all {
+				if len(query.States) == 0 {
+					// archived tasks are hidden by default
+					query.States = []pomo.TaskState{pomo.TaskTodo, pomo.TaskActive, pomo.TaskDone}
+				}
+				if *duration != "" {
+					duration, err := time.ParseDuration(*duration)
+					maybe(err)
+					query.Since = time.Now().Add(-duration)
+				}
+				if *since != "" {
+					start, err := parseDate(config, *since)
+					maybe(err)
+					query.Since = start
+				}
+				if *until != "" {
+					end, err := parseDate(config, *until)
+					maybe(err)
+					query.Until = end
+				}
+			}
 			db, err := pomo.NewStore(config.DBPath)
 			maybe(err)
 			defer db.Close()
 			maybe(db.With(func(tx *sql.Tx) error {
-				tasks, err := db.ReadTasks(tx)
-				maybe(err)
-				if *assend {
-					sort.Sort(sort.Reverse(pomo.ByID(tasks)))
-				}
-				if !*all {
-					tasks = pomo.After(time.Now().Add(-duration), tasks)
-				}
-				if *limit > 0 && (len(tasks) > *limit) {
-					tasks = tasks[0:*limit]
+				tasks, err := db.QueryTasks(tx, query)
+				if err != nil {
+					return err
 				}
 				if *asJSON {
-					maybe(json.NewEncoder(os.Stdout).Encode(tasks))
+					return json.NewEncoder(os.Stdout).Encode(tasks)
+				}
+				if *tree {
+					pomo.SummerizeTaskTree(config, tasks)
 					return nil
 				}
-				maybe(err)
 				pomo.SummerizeTasks(config, tasks)
 				return nil
 			}))
@@ -206,6 +645,342 @@ func list(config *pomo.Config) func(*cli.Cmd) {
 	}
 }
 
+// parseDate reads a date in the local time zone with
+// an optional time or in the configured date format.
+func parseDate(config *pomo.Config, value string) (time.Time, error) {
+	layouts := []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05", time.RFC3339}
+	if config.DateTimeFmt != "" {
+		layouts = append(layouts, config.DateTimeFmt)
+	}
+	for _, layout := range layouts {
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse date %q, expected YYYY-MM-DD [HH:MM]", value)
+}
+
+func report(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS]"
+		var (
+			by       = cmd.StringOpt("b by", "day", "group pomodoros by day, week, month, tag, project or tree")
+			format   = cmd.StringOpt("f format", "text", "output format text, json or csv")
+			duration = cmd.StringOpt("d duration", "", "only include pomodoros within this duration")
+		)
+		cmd.Action = func() {
+			var since time.Time
+			if *duration != "" {
+				duration, err := time.ParseDuration(*duration)
+				maybe(err)
+				since = time.Now().Add(-duration)
+			}
+			db, err := pomo.NewStore(config.DBPath)
+			maybe(err)
+			defer db.Close()
+			var tasks []*pomo.Task
+			maybe(db.With(func(tx *sql.Tx) error {
+				tasks, err = db.ReadTasks(tx)
+				return err
+			}))
+			report, err := pomo.NewReport(tasks, pomo.Grouping(*by), since)
+			maybe(err)
+			switch *format {
+			case "text":
+				maybe(report.WriteText(os.Stdout))
+			case "json":
+				maybe(json.NewEncoder(os.Stdout).Encode(report))
+			case "csv":
+				maybe(report.WriteCSV(os.Stdout))
+			default:
+				maybe(fmt.Errorf("unknown format %q", *format))
+			}
+		}
+	}
+}
+
+func export(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS]"
+		var (
+			format = cmd.StringOpt("f format", "", "export format json, csv or ical")
+			output = cmd.StringOpt("o output", "", "write to this file rather than stdout")
+		)
+		cmd.Action = func() {
+			exportFormat := pomo.ExportFormat(*format)
+			if exportFormat == "" {
+				exportFormat = pomo.FormatJSON
+				if *output != "" {
+					var err error
+					exportFormat, err = pomo.FormatFromPath(*output)
+					maybe(err)
+				}
+			}
+			db, err := pomo.NewStore(config.DBPath)
+			maybe(err)
+			defer db.Close()
+			var tasks []*pomo.Task
+			maybe(db.With(func(tx *sql.Tx) error {
+				tasks, err = db.ReadTasks(tx)
+				return err
+			}))
+			w := os.Stdout
+			if *output != "" {
+				w, err = os.Create(*output)
+				maybe(err)
+				defer w.Close()
+			}
+			maybe(pomo.ExportTasks(w, exportFormat, tasks))
+		}
+	}
+}
+
+func _import(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS] FILE"
+		cmd.LongDesc = `
+import tasks exported by pomo, tasks that were already imported
+are skipped unless --merge is given in which case any pomodoros
+missing from the existing task are added
+
+Examples:
+
+# combine the history of another machine
+pomo import --merge laptop.json
+# read csv from stdin
+pomo import -f csv -
+`
+		var (
+			format = cmd.StringOpt("f format", "", "import format json, csv or ical")
+			merge  = cmd.BoolOpt("m merge", false, "add missing pomodoros to duplicate tasks")
+			file   = cmd.StringArg("FILE", "", "file to import or - for stdin")
+		)
+		cmd.Action = func() {
+			importFormat := pomo.ExportFormat(*format)
+			if importFormat == "" {
+				var err error
+				importFormat, err = pomo.FormatFromPath(*file)
+				maybe(err)
+			}
+			r := os.Stdin
+			if *file != "-" {
+				f, err := os.Open(*file)
+				maybe(err)
+				defer f.Close()
+				r = f
+			}
+			tasks, err := pomo.ParseTasks(r, importFormat)
+			maybe(err)
+			db, err := pomo.NewStore(config.DBPath)
+			maybe(err)
+			defer db.Close()
+			var result *pomo.ImportResult
+			maybe(db.With(func(tx *sql.Tx) error {
+				result, err = db.ImportTasks(tx, tasks, *merge)
+				return err
+			}))
+			fmt.Printf("imported %d tasks, merged %d and skipped %d\n",
+				result.Created, result.Merged, result.Skipped)
+		}
+	}
+}
+
+// pomodoroTimes resolves the start and end of a pomodoro
+// from any combination of --start, --end and --ago with
+// missing times derived from the duration of the task.
+func pomodoroTimes(config *pomo.Config, task *pomo.Task, start, end, ago string) (time.Time, time.Time, error) {
+	var (
+		startTime time.Time
+		endTime   time.Time
+		err       error
+	)
+	if ago != "" {
+		if start != "" {
+			return startTime, endTime, fmt.Errorf("--ago cannot be combined with --start")
+		}
+		duration, err := time.ParseDuration(ago)
+		if err != nil {
+			return startTime, endTime, err
+		}
+		startTime = time.Now().Add(-duration)
+	}
+	if start != "" {
+		startTime, err = parseDate(config, start)
+		if err != nil {
+			return startTime, endTime, err
+		}
+	}
+	if end != "" {
+		endTime, err = parseDate(config, end)
+		if err != nil {
+			return startTime, endTime, err
+		}
+	}
+	switch {
+	case startTime.IsZero() && endTime.IsZero():
+		return startTime, endTime, fmt.Errorf("one of --start, --end or --ago is required")
+	case startTime.IsZero():
+		startTime = endTime.Add(-task.Duration)
+	case endTime.IsZero() && ago != "":
+		endTime = time.Now()
+	case endTime.IsZero():
+		endTime = startTime.Add(task.Duration)
+	}
+	if !endTime.After(startTime) {
+		return startTime, endTime, fmt.Errorf("pomodoro must end after it starts")
+	}
+	return startTime, endTime, nil
+}
+
+func validStatus(status string) (pomo.PomodoroStatus, error) {
+	switch pomo.PomodoroStatus(status) {
+	case pomo.PomodoroCompleted, pomo.PomodoroInterrupted, pomo.PomodoroAbandoned:
+		return pomo.PomodoroStatus(status), nil
+	}
+	return "", fmt.Errorf("unknown status %q, expected completed, interrupted or abandoned", status)
+}
+
+func _log(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS] TASK_ID"
+		cmd.LongDesc = `
+record a pomodoro that was done away from pomo, a missing
+start or end is derived from the duration of the task
+
+Examples:
+
+# a pomodoro that has just ended
+pomo log --ago 25m 1
+pomo log --start "2022-01-03 09:00" 1
+pomo log --start "2022-01-03 09:00" --end "2022-01-03 09:40" -s interrupted 1
+`
+		var (
+			taskID = cmd.IntArg("TASK_ID", -1, "ID of the task the pomodoro belongs to")
+			start  = cmd.StringOpt("start", "", "when the pomodoro started")
+			end    = cmd.StringOpt("end", "", "when the pomodoro ended")
+			ago    = cmd.StringOpt("ago", "", "the pomodoro started this long ago and ended now")
+			status = cmd.StringOpt("s status", "completed", "completed, interrupted or abandoned")
+		)
+		cmd.Action = func() {
+			pomodoroStatus, err := validStatus(*status)
+			maybe(err)
+			db, err := pomo.NewStore(config.DBPath)
+			maybe(err)
+			defer db.Close()
+			maybe(db.With(func(tx *sql.Tx) error {
+				task, err := db.ReadTask(tx, *taskID)
+				if err != nil {
+					return err
+				}
+				startTime, endTime, err := pomodoroTimes(config, task, *start, *end, *ago)
+				if err != nil {
+					return err
+				}
+				pomodoroID, err := db.CreatePomodoro(tx, task.ID, pomo.Pomodoro{
+					Start:  startTime,
+					End:    endTime,
+					Status: pomodoroStatus,
+				})
+				if err != nil {
+					return err
+				}
+				fmt.Println(pomodoroID)
+				return nil
+			}))
+		}
+	}
+}
+
+func pomodoros(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Command("list ls", "list the pomodoros of a task with their IDs", func(cmd *cli.Cmd) {
+			cmd.Spec = "TASK_ID"
+			var taskID = cmd.IntArg("TASK_ID", -1, "ID of the task")
+			cmd.Action = func() {
+				db, err := pomo.NewStore(config.DBPath)
+				maybe(err)
+				defer db.Close()
+				maybe(db.With(func(tx *sql.Tx) error {
+					pomodoros, err := db.ReadPomodoros(tx, *taskID)
+					if err != nil {
+						return err
+					}
+					for _, pomodoro := range pomodoros {
+						fmt.Printf("%d: [%s] [%s] %s\n",
+							pomodoro.ID,
+							pomodoro.Start.Format(config.DateTimeFmt),
+							pomodoro.Worked().Truncate(time.Second),
+							pomodoro.Status,
+						)
+					}
+					return nil
+				}))
+			}
+		})
+		cmd.Command("edit", "change when a pomodoro started or ended or its status", func(cmd *cli.Cmd) {
+			cmd.Spec = "[OPTIONS] POMODORO_ID"
+			var (
+				pomodoroID = cmd.IntArg("POMODORO_ID", -1, "ID of the pomodoro")
+				start      = cmd.StringOpt("start", "", "when the pomodoro started")
+				end        = cmd.StringOpt("end", "", "when the pomodoro ended")
+				status     = cmd.StringOpt("s status", "", "completed, interrupted or abandoned")
+			)
+			cmd.Action = func() {
+				db, err := pomo.NewStore(config.DBPath)
+				maybe(err)
+				defer db.Close()
+				maybe(db.With(func(tx *sql.Tx) error {
+					pomodoro, _, err := db.ReadPomodoro(tx, *pomodoroID)
+					if err != nil {
+						return err
+					}
+					if *start != "" {
+						pomodoro.Start, err = parseDate(config, *start)
+						if err != nil {
+							return err
+						}
+					}
+					if *end != "" {
+						pomodoro.End, err = parseDate(config, *end)
+						if err != nil {
+							return err
+						}
+					}
+					if *status != "" {
+						pomodoro.Status, err = validStatus(*status)
+						if err != nil {
+							return err
+						}
+					}
+					if !pomodoro.End.After(pomodoro.Start) {
+						return fmt.Errorf("pomodoro must end after it starts")
+					}
+					return db.UpdatePomodoro(tx, *pomodoro)
+				}))
+			}
+		})
+		cmd.Command("delete rm", "delete one or more pomodoros", func(cmd *cli.Cmd) {
+			cmd.Spec = "POMODORO_ID..."
+			var pomodoroIDs = cmd.IntsArg("POMODORO_ID", nil, "ID of the pomodoro")
+			cmd.Action = func() {
+				db, err := pomo.NewStore(config.DBPath)
+				maybe(err)
+				defer db.Close()
+				maybe(db.With(func(tx *sql.Tx) error {
+					for _, pomodoroID := range *pomodoroIDs {
+						err := db.DeletePomodoro(tx, pomodoroID)
+						if err != nil {
+							return fmt.Errorf("deleting pomodoro %d: %s", pomodoroID, err)
+						}
+					}
+					return nil
+				}))
+			}
+		})
+	}
+}
+
 func _delete(config *pomo.Config) func(*cli.Cmd) {
 	return func(cmd *cli.Cmd) {
 		cmd.Spec = "[OPTIONS] [TASK_ID...]"
@@ -247,6 +1022,93 @@ pomo delete 5 10 20
 	}
 }
 
+func tags(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS]"
+		cmd.Action = func() {
+			db, err := pomo.NewStore(config.DBPath)
+			maybe(err)
+			defer db.Close()
+			maybe(db.With(func(tx *sql.Tx) error {
+				tags, err := db.ReadTags(tx)
+				if err != nil {
+					return err
+				}
+				for _, tag := range tags {
+					fmt.Println(tag)
+				}
+				return nil
+			}))
+		}
+		cmd.Command("rename mv", "rename a tag or merge it into an existing one", func(cmd *cli.Cmd) {
+			cmd.Spec = "FROM TO"
+			var (
+				from = cmd.StringArg("FROM", "", "tag to rename")
+				to   = cmd.StringArg("TO", "", "new tag name")
+			)
+			cmd.Action = func() {
+				db, err := pomo.NewStore(config.DBPath)
+				maybe(err)
+				defer db.Close()
+				maybe(db.With(func(tx *sql.Tx) error {
+					return db.RenameTag(tx, *from, *to)
+				}))
+			}
+		})
+	}
+}
+
+func projects(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS]"
+		cmd.Action = func() {
+			db, err := pomo.NewStore(config.DBPath)
+			maybe(err)
+			defer db.Close()
+			maybe(db.With(func(tx *sql.Tx) error {
+				projects, err := db.ReadProjects(tx)
+				if err != nil {
+					return err
+				}
+				w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+				fmt.Fprintf(w, "PROJECT\tTASKS\tCOMPLETED\tPLANNED\n")
+				for _, project := range projects {
+					tasks, err := db.QueryTasks(tx, pomo.TaskQuery{Project: project})
+					if err != nil {
+						return err
+					}
+					var completed, planned int
+					for _, task := range tasks {
+						completed += task.Completed()
+						planned += task.NPomodoros
+					}
+					fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", project, len(tasks), completed, planned)
+				}
+				return w.Flush()
+			}))
+		}
+		cmd.Command("move mv", "move a task and its sub-tasks to a project", func(cmd *cli.Cmd) {
+			cmd.Spec = "TASK_ID [PROJECT]"
+			cmd.LongDesc = `
+move a task and its sub-tasks to a project, the
+tasks are removed from any project if it is omitted
+`
+			var (
+				taskID  = cmd.IntArg("TASK_ID", -1, "ID of the task to move")
+				project = cmd.StringArg("PROJECT", "", "project to move the task to")
+			)
+			cmd.Action = func() {
+				db, err := pomo.NewStore(config.DBPath)
+				maybe(err)
+				defer db.Close()
+				maybe(db.With(func(tx *sql.Tx) error {
+					return db.MoveTask(tx, *taskID, *project)
+				}))
+			}
+		})
+	}
+}
+
 func _status(config *pomo.Config) func(*cli.Cmd) {
 	return func(cmd *cli.Cmd) {
 		cmd.Spec = "[OPTIONS]"
@@ -276,11 +1138,177 @@ func _status(config *pomo.Config) func(*cli.Cmd) {
 	}
 }
 
+func watch(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS]"
+		var (
+			asJSON = cmd.BoolOpt("json", false, "output each event as JSON")
+			ticks  = cmd.BoolOpt("ticks", false, "output the status every second")
+		)
+		cmd.Action = func() {
+			client, err := pomo.NewClient(config.SocketPath)
+			if err != nil {
+				maybe(fmt.Errorf("no running pomo session: %s", err))
+			}
+			defer client.Close()
+			status, events, err := client.Subscribe(*ticks)
+			maybe(err)
+			if !*asJSON {
+				fmt.Println(pomo.FormatStatus(*status))
+			}
+			encoder := json.NewEncoder(os.Stdout)
+			for event := range events {
+				if *asJSON {
+					maybe(encoder.Encode(event))
+				} else {
+					fmt.Println(pomo.FormatStatus(event.Status))
+				}
+			}
+		}
+	}
+}
+
+func serve(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS]"
+		cmd.LongDesc = `
+serve the HTTP API until interrupted, the API is also served by running
+sessions when apiListen is set in the config unless pomo serve is already
+listening on the same address.
+
+Examples:
+
+pomo serve --listen 127.0.0.1:7070
+pomo serve --listen unix:/run/user/1000/pomo-api.sock
+curl localhost:7070/api/status
+`
+		// the config is loaded after the options are declared
+		// so apiListen is only read once the command runs
+		var listen = cmd.StringOpt("l listen", "", "loopback host:port or unix:PATH to listen on, defaults to apiListen")
+		cmd.Action = func() {
+			address := *listen
+			if address == "" {
+				address = config.APIListen
+			}
+			if address == "" {
+				maybe(fmt.Errorf("set apiListen in the config or pass --listen"))
+			}
+			maybe(pomo.RunAPI(config, address))
+		}
+	}
+}
+
+// setState moves one or more tasks to
+// another state of their lifecycle.
+func setState(config *pomo.Config, state pomo.TaskState) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "TASK_ID..."
+		var taskIds = cmd.IntsArg("TASK_ID", nil, "ID of the task")
+		cmd.Action = func() {
+			db, err := pomo.NewStore(config.DBPath)
+			maybe(err)
+			defer db.Close()
+			maybe(db.With(func(tx *sql.Tx) error {
+				for _, taskID := range *taskIds {
+					err := db.SetTaskState(tx, taskID, state, time.Now())
+					if err != nil {
+						return fmt.Errorf("updating task %d: %s", taskID, err)
+					}
+				}
+				return nil
+			}))
+		}
+	}
+}
+
+// control sends a single command to the
+// running pomo session.
+func control(config *pomo.Config, command pomo.Command) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS]"
+		cmd.Action = func() {
+			client, err := pomo.NewClient(config.SocketPath)
+			if err != nil {
+				maybe(fmt.Errorf("no running pomo session: %s", err))
+			}
+			defer client.Close()
+			_, err = client.Do(command)
+			maybe(err)
+		}
+	}
+}
+
+func extend(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Spec = "[OPTIONS] [COUNT]"
+		var count = cmd.IntArg("COUNT", 1, "number of pomodoros to add to the session")
+		cmd.Action = func() {
+			client, err := pomo.NewClient(config.SocketPath)
+			if err != nil {
+				maybe(fmt.Errorf("no running pomo session: %s", err))
+			}
+			defer client.Close()
+			_, err = client.Extend(*count)
+			maybe(err)
+		}
+	}
+}
+
+func stats(config *pomo.Config) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Command("estimates", "compare estimated and actual pomodoros by tag", func(cmd *cli.Cmd) {
+			cmd.Spec = "[OPTIONS]"
+			cmd.LongDesc = `
+compare the pomodoros finished tasks were estimated to take with the
+pomodoros they took by tag and by the period they were finished in.
+A ratio above one means tasks were underestimated, the accuracy of a
+task is the smaller of its estimate and actual pomodoros divided by
+the larger and is averaged over the tasks.
+`
+			var (
+				by       = cmd.StringOpt("b by", "month", "group tasks by the day, week or month they were finished")
+				format   = cmd.StringOpt("f format", "text", "output format text, json or csv")
+				duration = cmd.StringOpt("d duration", "", "only include tasks finished within this duration")
+				tags     = cmd.StringsOpt("t tag", []string{}, "only include tasks with any of these tags")
+			)
+			cmd.Action = func() {
+				var since time.Time
+				if *duration != "" {
+					duration, err := time.ParseDuration(*duration)
+					maybe(err)
+					since = time.Now().Add(-duration)
+				}
+				db, err := pomo.NewStore(config.DBPath)
+				maybe(err)
+				defer db.Close()
+				var tasks []*pomo.Task
+				maybe(db.With(func(tx *sql.Tx) error {
+					tasks, err = db.QueryTasks(tx, pomo.TaskQuery{Tags: *tags})
+					return err
+				}))
+				estimates, err := pomo.NewEstimates(tasks, pomo.Grouping(*by), since)
+				maybe(err)
+				switch *format {
+				case "text":
+					maybe(estimates.WriteText(os.Stdout))
+				case "json":
+					maybe(json.NewEncoder(os.Stdout).Encode(estimates))
+				case "csv":
+					maybe(estimates.WriteCSV(os.Stdout))
+				default:
+					maybe(fmt.Errorf("unknown format %q", *format))
+				}
+			}
+		})
+	}
+}
+
 func _config(config *pomo.Config) func(*cli.Cmd) {
 	return func(cmd *cli.Cmd) {
 		cmd.Spec = "[OPTIONS]"
 		cmd.Action = func() {
-			maybe(json.NewEncoder(os.Stdout).Encode(config))
+			redacted := config.Redacted()
+			maybe(json.NewEncoder(os.Stdout).Encode(&redacted))
 		}
 	}
 }
@@ -298,12 +1326,35 @@ func New(config *pomo.Config) *cli.Cli {
 	app.Version("v version", pomo.Version)
 	app.Command("start s", "start a new task", start(config))
 	app.Command("init", "initialize the sqlite database", initialize(config))
+	app.Command("migrate", "upgrade the sqlite database schema", migrate(config))
 	app.Command("config cf", "display the current configuration", _config(config))
 	app.Command("create c", "create a new task without starting", create(config))
+	app.Command("edit e", "edit a stored task", edit(config))
 	app.Command("begin b", "begin requested pomodoro", begin(config))
+	app.Command("daemon", "run a task session without a user interface", daemon(config))
 	app.Command("list l", "list historical tasks", list(config))
+	app.Command("ui", "browse the task history interactively", browse(config))
+	app.Command("report r", "report time spent by day, week, month, tag, project or tree", report(config))
+	app.Command("stats", "show statistics about estimates", stats(config))
+	app.Command("export", "export task history as json, csv or ical", export(config))
+	app.Command("import", "import task history exported by pomo", _import(config))
+	app.Command("log", "record a pomodoro done away from pomo", _log(config))
+	app.Command("pomodoros p", "list, edit or delete the pomodoros of a task", pomodoros(config))
+	app.Command("todo", "move tasks back to the backlog", setState(config, pomo.TaskTodo))
+	app.Command("done", "mark tasks as done", setState(config, pomo.TaskDone))
+	app.Command("archive", "archive tasks so they are hidden from list", setState(config, pomo.TaskArchived))
 	app.Command("delete d", "delete a stored task", _delete(config))
+	app.Command("tags", "list or rename tags", tags(config))
+	app.Command("projects", "list projects or move tasks between them", projects(config))
 	app.Command("status st", "output the current status", _status(config))
+	app.Command("watch w", "output the status each time it changes", watch(config))
+	app.Command("serve", "serve the HTTP API", serve(config))
+	app.Command("pause", "pause the running pomodoro", control(config, pomo.PauseCommand))
+	app.Command("resume", "resume the paused pomodoro or restore the last session", resume(config))
+	app.Command("stop", "stop the running session", control(config, pomo.StopCommand))
+	app.Command("extend", "continue the running session past the estimate of the task", extend(config))
+	app.Command("finish", "end the running session early and mark the task as done", control(config, pomo.FinishCommand))
+	app.Command("next", "end the current break and begin the next pomodoro", control(config, pomo.SkipCommand))
 	return app
 }
 
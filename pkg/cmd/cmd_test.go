@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	pomo "github.com/kevinschoon/pomo/pkg/internal"
 )
@@ -48,3 +49,90 @@ func TestPomoCreate(t *testing.T) {
 		return nil
 	})
 }
+
+func TestPomoTagsRename(t *testing.T) {
+	store, config := initTestConfig(t)
+	cmd := New(config)
+	checkErr(t, cmd.Run([]string{"pomo", "create", "-t", "fuu", "bar"}))
+	checkErr(t, cmd.Run([]string{"pomo", "tags", "rename", "fuu", "baz"}))
+	store.With(func(tx *sql.Tx) error {
+		tasks, err := store.ReadTasksByTag(tx, "baz")
+		checkErr(t, err)
+		if len(tasks) != 1 {
+			checkErr(t, fmt.Errorf("expected 1 task tagged baz, got %d", len(tasks)))
+		}
+		return nil
+	})
+}
+
+func TestPomoEdit(t *testing.T) {
+	store, config := initTestConfig(t)
+	cmd := New(config)
+	checkErr(t, cmd.Run([]string{"pomo", "create", "-t", "fuu", "-t", "bar", "baz"}))
+	checkErr(t, cmd.Run([]string{
+		"pomo", "edit", "-m", "qux", "-p", "2", "-d", "50m",
+		"--add-tag", "quux", "--remove-tag", "fuu", "1",
+	}))
+	store.With(func(tx *sql.Tx) error {
+		task, err := store.ReadTask(tx, 1)
+		checkErr(t, err)
+		if task.Message != "qux" || task.NPomodoros != 2 || task.Duration != 50*time.Minute {
+			checkErr(t, fmt.Errorf("unexpected task %+v", task))
+		}
+		if len(task.Tags) != 2 || task.Tags[0] != "bar" || task.Tags[1] != "quux" {
+			checkErr(t, fmt.Errorf("expected tags [bar quux], got %v", task.Tags))
+		}
+		return nil
+	})
+	checkErr(t, New(config).Run([]string{"pomo", "edit", "-t", "fuu", "1"}))
+	store.With(func(tx *sql.Tx) error {
+		task, err := store.ReadTask(tx, 1)
+		checkErr(t, err)
+		if len(task.Tags) != 1 || task.Tags[0] != "fuu" {
+			checkErr(t, fmt.Errorf("expected tags [fuu], got %v", task.Tags))
+		}
+		return nil
+	})
+}
+
+func TestPomoLog(t *testing.T) {
+	store, config := initTestConfig(t)
+	checkErr(t, New(config).Run([]string{"pomo", "create", "-d", "30m", "fuu"}))
+	checkErr(t, New(config).Run([]string{"pomo", "log", "--start", "2022-01-03 09:00", "1"}))
+	checkErr(t, New(config).Run([]string{"pomo", "log", "--ago", "10m", "-s", "interrupted", "1"}))
+	checkErr(t, New(config).Run([]string{"pomo", "pomodoros", "rm", "2"}))
+	store.With(func(tx *sql.Tx) error {
+		task, err := store.ReadTask(tx, 1)
+		checkErr(t, err)
+		if len(task.Pomodoros) != 1 || task.Pomodoros[0].ID != 1 {
+			checkErr(t, fmt.Errorf("expected a single pomodoro, got %v", task.Pomodoros))
+		}
+		expected := time.Date(2022, 1, 3, 9, 0, 0, 0, time.Local)
+		if pomodoro := task.Pomodoros[0]; !pomodoro.Start.Equal(expected) || pomodoro.Duration() != 30*time.Minute {
+			checkErr(t, fmt.Errorf("unexpected pomodoro %s - %s", pomodoro.Start, pomodoro.End))
+		}
+		return nil
+	})
+}
+
+func TestPomoProjects(t *testing.T) {
+	store, config := initTestConfig(t)
+	checkErr(t, New(config).Run([]string{"pomo", "create", "--project", "web", "site"}))
+	checkErr(t, New(config).Run([]string{"pomo", "create", "--parent", "1", "pages"}))
+	checkErr(t, New(config).Run([]string{"pomo", "create", "other"}))
+	checkErr(t, New(config).Run([]string{"pomo", "edit", "--parent", "2", "3"}))
+	checkErr(t, New(config).Run([]string{"pomo", "projects", "move", "2", "blog"}))
+	store.With(func(tx *sql.Tx) error {
+		tasks, err := store.QueryTasks(tx, pomo.TaskQuery{Project: "blog"})
+		checkErr(t, err)
+		if len(tasks) != 2 || tasks[0].ID != 2 || tasks[1].ID != 3 || tasks[1].ParentID != 2 {
+			checkErr(t, fmt.Errorf("expected tasks 2 and 3 in blog, got %v", tasks))
+		}
+		task, err := store.ReadTask(tx, 1)
+		checkErr(t, err)
+		if task.Project != "web" {
+			checkErr(t, fmt.Errorf("expected task 1 to stay in web, got %q", task.Project))
+		}
+		return nil
+	})
+}
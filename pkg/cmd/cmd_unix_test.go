@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPomoServeConfig(t *testing.T) {
+	_, config := initTestConfig(t)
+	socketPath := filepath.Join(config.BasePath, "api.sock")
+	configPath := filepath.Join(config.BasePath, "config.json")
+	raw, err := json.Marshal(map[string]string{
+		"basePath":   config.BasePath,
+		"dbPath":     config.DBPath,
+		"socketPath": config.SocketPath,
+		"iconPath":   config.IconPath,
+		"apiListen":  "unix:" + socketPath,
+	})
+	checkErr(t, err)
+	checkErr(t, ioutil.WriteFile(configPath, raw, 0644))
+
+	// the test receives SIGINT as well so it is
+	// not killed if it arrives before pomo serve
+	// is waiting for it
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	served := make(chan error, 1)
+	go func() { served <- New(config).Run([]string{"pomo", "-p", configPath, "serve"}) }()
+	for i := 0; ; i++ {
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatalf("pomo serve is not listening on apiListen: %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 50; i++ {
+		checkErr(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+		select {
+		case err := <-served:
+			checkErr(t, err)
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	t.Fatal("pomo serve did not stop on SIGINT")
+}
@@ -0,0 +1,633 @@
+package pomo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// sseKeepalive is how often a comment is written to
+// event streams so idle connections are not closed.
+const sseKeepalive = 15 * time.Second
+
+// apiShutdownTimeout is how long Stop waits for requests
+// to finish before their connections are closed.
+const apiShutdownTimeout = 2 * time.Second
+
+// APIServer serves tasks, pomodoros and control of the running
+// session as JSON over HTTP. The session is reached through the
+// Unix socket so the API may be served by a session or on its own
+// by pomo serve.
+type APIServer struct {
+	config   *Config
+	store    *Store
+	listener net.Listener
+	server   *http.Server
+	// unix is set when listening on a Unix socket,
+	// the Host header is not checked for those.
+	unix bool
+	// stopping is closed by Stop to end event streams
+	stopping chan struct{}
+	// handling counts requests which may use the store
+	handling sync.WaitGroup
+}
+
+// NewAPIServer listens on listen which is either host:port
+// with a loopback host or unix:PATH for a Unix socket.
+func NewAPIServer(config *Config, listen string) (*APIServer, error) {
+	api := &APIServer{config: config, stopping: make(chan struct{})}
+	if strings.HasPrefix(listen, "unix:") {
+		socketPath := strings.TrimPrefix(listen, "unix:")
+		// remove the socket left behind after a crash
+		if _, err := os.Stat(socketPath); err == nil {
+			if conn, err := net.Dial("unix", socketPath); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("socket %s is already in use", socketPath)
+			}
+			os.Remove(socketPath)
+		}
+		listener, err := net.Listen("unix", socketPath)
+		if err != nil {
+			return nil, err
+		}
+		api.listener = listener
+		api.unix = true
+	} else {
+		host, _, err := net.SplitHostPort(listen)
+		if err != nil {
+			return nil, err
+		}
+		if !loopbackHost(host) {
+			return nil, fmt.Errorf("the api may only listen on localhost, not %q", host)
+		}
+		listener, err := net.Listen("tcp", listen)
+		if err != nil {
+			return nil, err
+		}
+		api.listener = listener
+	}
+	store, err := NewStore(config.DBPath)
+	if err != nil {
+		api.listener.Close()
+		return nil, err
+	}
+	api.store = store
+	api.server = &http.Server{Handler: api}
+	return api, nil
+}
+
+// ServeAPI serves the API configured with APIListen alongside
+// a session, the session continues without it if it cannot
+// listen because pomo serve is already listening for example.
+func ServeAPI(config *Config) (stop func()) {
+	if config.APIListen == "" {
+		return func() {}
+	}
+	api, err := NewAPIServer(config, config.APIListen)
+	if err != nil {
+		log.Printf("not serving the api: %s", err)
+		return func() {}
+	}
+	api.Start()
+	return api.Stop
+}
+
+// RunAPI serves the API on its own until the
+// process receives SIGINT or SIGTERM.
+func RunAPI(config *Config, listen string) error {
+	api, err := NewAPIServer(config, listen)
+	if err != nil {
+		return err
+	}
+	api.Start()
+	defer api.Stop()
+	log.Printf("serving the api on %s", api.Addr())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	<-signals
+	return nil
+}
+
+// Addr is the address the API is listening on
+func (a *APIServer) Addr() net.Addr { return a.listener.Addr() }
+
+func (a *APIServer) Start() {
+	go a.server.Serve(a.listener)
+}
+
+// Stop waits for the requests in progress to finish
+// before the store is closed, connections that are
+// still busy after apiShutdownTimeout are closed.
+func (a *APIServer) Stop() {
+	close(a.stopping)
+	ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+	defer cancel()
+	err := a.server.Shutdown(ctx)
+	if err != nil {
+		a.server.Close()
+	}
+	// closing a connection does not end its handler
+	a.handling.Wait()
+	a.store.Close()
+}
+
+func loopbackHost(host string) bool {
+	host = strings.Trim(host, "[]")
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// apiError is written as the body of failed requests
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+// allow writes an error unless the request has one of the methods
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	return false
+}
+
+func (a *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.handling.Add(1)
+	defer a.handling.Done()
+	// refuse pages served from other hosts which
+	// resolve their name to this machine
+	if !a.unix {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if !loopbackHost(host) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host %q is not allowed", r.Host))
+			return
+		}
+	}
+	// browsers only send JSON or DELETE requests to other origins
+	// after a preflight request which is refused, so other pages
+	// cannot change anything.
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete &&
+		!strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("requests must be sent as application/json"))
+		return
+	}
+	if r.URL.Path == "/metrics" {
+		if allow(w, r, http.MethodGet) {
+			a.metrics(w)
+		}
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "api" || len(parts) < 2 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+		return
+	}
+	switch {
+	case len(parts) == 2 && parts[1] == "status":
+		if allow(w, r, http.MethodGet) {
+			a.status(w)
+		}
+	case len(parts) == 3 && parts[1] == "session":
+		if allow(w, r, http.MethodPost) {
+			a.control(w, r, Command(parts[2]))
+		}
+	case len(parts) == 2 && parts[1] == "events":
+		if allow(w, r, http.MethodGet) {
+			a.events(w, r)
+		}
+	case len(parts) == 2 && parts[1] == "tasks":
+		if allow(w, r, http.MethodGet, http.MethodPost) {
+			if r.Method == http.MethodGet {
+				a.listTasks(w, r)
+			} else {
+				a.createTask(w, r)
+			}
+		}
+	case len(parts) >= 3 && len(parts) <= 4 && (parts[1] == "tasks" || parts[1] == "pomodoros"):
+		id, err := strconv.Atoi(parts[2])
+		if err != nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+			return
+		}
+		a.resource(w, r, parts[1], id, strings.Join(parts[3:], ""))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+	}
+}
+
+// resource handles requests for a single task or pomodoro
+func (a *APIServer) resource(w http.ResponseWriter, r *http.Request, kind string, id int, sub string) {
+	switch {
+	case kind == "pomodoros" && sub == "":
+		if allow(w, r, http.MethodGet) {
+			a.readPomodoro(w, id)
+		}
+	case kind == "tasks" && sub == "":
+		if allow(w, r, http.MethodGet, http.MethodDelete) {
+			if r.Method == http.MethodGet {
+				a.readTask(w, id)
+			} else {
+				a.deleteTask(w, id)
+			}
+		}
+	case kind == "tasks" && sub == "pomodoros":
+		if allow(w, r, http.MethodGet) {
+			a.readTaskPomodoros(w, id)
+		}
+	case kind == "tasks" && sub == "state":
+		if allow(w, r, http.MethodPut) {
+			a.setTaskState(w, r, id)
+		}
+	case kind == "tasks" && sub == "begin":
+		if allow(w, r, http.MethodPost) {
+			a.begin(w, id)
+		}
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+	}
+}
+
+// storeError writes a failure to read or write the store
+func storeError(w http.ResponseWriter, err error, what string, id int) {
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s %d does not exist", what, id))
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+func (a *APIServer) status(w http.ResponseWriter) {
+	client, err := NewClient(a.config.SocketPath)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no session is running"))
+		return
+	}
+	defer client.Close()
+	status, err := client.Status()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (a *APIServer) control(w http.ResponseWriter, r *http.Request, command Command) {
+	switch command {
+	case PauseCommand, ResumeCommand, TogglePauseCommand, StopCommand, SkipCommand, ExtendCommand, FinishCommand:
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown command %q", command))
+		return
+	}
+	request := Request{Version: ProtocolVersion, Command: command}
+	if r.ContentLength != 0 {
+		body := struct {
+			Count int `json:"count"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %s", err))
+			return
+		}
+		request.Count = body.Count
+	}
+	client, err := NewClient(a.config.SocketPath)
+	if err != nil {
+		writeError(w, http.StatusConflict, fmt.Errorf("no session is running"))
+		return
+	}
+	defer client.Close()
+	status, err := client.send(request)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// events streams the events of the running session as
+// Server-Sent Events, the first is the current status.
+func (a *APIServer) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	client, err := NewClient(a.config.SocketPath)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no session is running"))
+		return
+	}
+	defer client.Close()
+	status, events, err := client.Subscribe(r.URL.Query().Get("ticks") == "true")
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	// let the subscription end once the client is closed
+	defer func() {
+		client.Close()
+		for range events {
+		}
+	}()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	write := func(name string, v interface{}) error {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, raw)
+		flusher.Flush()
+		return err
+	}
+	if write("status", status) != nil {
+		return
+	}
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok || write(string(event.Type), event) != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-a.stopping:
+			return
+		}
+	}
+}
+
+// metrics writes the Prometheus metrics of
+// the running session if any and the store.
+func (a *APIServer) metrics(w http.ResponseWriter) {
+	var status *Status
+	if client, err := NewClient(a.config.SocketPath); err == nil {
+		defer client.Close()
+		status, err = client.Status()
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+	}
+	var metrics *Metrics
+	err := a.store.With(func(tx *sql.Tx) error {
+		var err error
+		metrics, err = a.store.ReadMetrics(tx)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	metrics.Status = status
+	w.Header().Set("Content-Type", MetricsContentType)
+	metrics.WriteText(w)
+}
+
+// listTasks returns the tasks matching the filter q which
+// is given in the same form as the filter of pomo ui.
+func (a *APIServer) listTasks(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query, err := ParseFilter(params.Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	for name, value := range map[string]*int{"limit": &query.Limit, "offset": &query.Offset} {
+		if raw := params.Get(name); raw != "" {
+			*value, err = strconv.Atoi(raw)
+			if err != nil || *value < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s %q", name, raw))
+				return
+			}
+		}
+	}
+	var tasks []*Task
+	err = a.store.With(func(tx *sql.Tx) error {
+		tasks, err = a.store.QueryTasks(tx, query)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if tasks == nil {
+		tasks = []*Task{}
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// apiTask is the body of a request to create a task
+type apiTask struct {
+	Message    string   `json:"message"`
+	Tags       []string `json:"tags"`
+	NPomodoros int      `json:"n_pomodoros"`
+	Duration   string   `json:"duration"`
+	Project    string   `json:"project"`
+	ParentID   int      `json:"parent_id"`
+}
+
+func (a *APIServer) createTask(w http.ResponseWriter, r *http.Request) {
+	body := apiTask{NPomodoros: 4, Duration: "25m"}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid task: %s", err))
+		return
+	}
+	duration, err := time.ParseDuration(body.Duration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid task: %s", err))
+		return
+	}
+	task := Task{
+		Message:    body.Message,
+		Tags:       body.Tags,
+		NPomodoros: body.NPomodoros,
+		Duration:   duration,
+		Project:    body.Project,
+		ParentID:   body.ParentID,
+	}
+	err = task.Validate()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var created *Task
+	err = a.store.With(func(tx *sql.Tx) error {
+		taskID, err := a.store.CreateTask(tx, task)
+		if err != nil {
+			return err
+		}
+		created, err = a.store.ReadTask(tx, taskID)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (a *APIServer) readTask(w http.ResponseWriter, taskID int) {
+	var task *Task
+	err := a.store.With(func(tx *sql.Tx) error {
+		read, err := a.store.ReadTask(tx, taskID)
+		task = read
+		return err
+	})
+	if err != nil {
+		storeError(w, err, "task", taskID)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+func (a *APIServer) deleteTask(w http.ResponseWriter, taskID int) {
+	err := a.store.With(func(tx *sql.Tx) error {
+		_, err := a.store.ReadTask(tx, taskID)
+		if err != nil {
+			return err
+		}
+		return a.store.DeleteTask(tx, taskID)
+	})
+	if err != nil {
+		storeError(w, err, "task", taskID)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *APIServer) readTaskPomodoros(w http.ResponseWriter, taskID int) {
+	var task *Task
+	err := a.store.With(func(tx *sql.Tx) error {
+		read, err := a.store.ReadTask(tx, taskID)
+		task = read
+		return err
+	})
+	if err != nil {
+		storeError(w, err, "task", taskID)
+		return
+	}
+	pomodoros := task.Pomodoros
+	if pomodoros == nil {
+		pomodoros = []*Pomodoro{}
+	}
+	writeJSON(w, http.StatusOK, pomodoros)
+}
+
+func (a *APIServer) setTaskState(w http.ResponseWriter, r *http.Request, taskID int) {
+	body := struct {
+		State TaskState `json:"state"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %s", err))
+		return
+	}
+	if !body.State.Valid() {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown task state %q", body.State))
+		return
+	}
+	var task *Task
+	err = a.store.With(func(tx *sql.Tx) error {
+		_, err := a.store.ReadTask(tx, taskID)
+		if err != nil {
+			return err
+		}
+		err = a.store.SetTaskState(tx, taskID, body.State, time.Now())
+		if err != nil {
+			return err
+		}
+		task, err = a.store.ReadTask(tx, taskID)
+		return err
+	})
+	if err != nil {
+		storeError(w, err, "task", taskID)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+// begin starts a session for the task in a background daemon
+func (a *APIServer) begin(w http.ResponseWriter, taskID int) {
+	if client, err := NewClient(a.config.SocketPath); err == nil {
+		client.Close()
+		writeError(w, http.StatusConflict, fmt.Errorf("a session is already running"))
+		return
+	}
+	err := a.store.With(func(tx *sql.Tx) error {
+		_, err := a.store.ReadTask(tx, taskID)
+		return err
+	})
+	if err != nil {
+		storeError(w, err, "task", taskID)
+		return
+	}
+	pid, err := Detach(a.config, taskID, false)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, struct {
+		PID int `json:"pid"`
+	}{pid})
+}
+
+func (a *APIServer) readPomodoro(w http.ResponseWriter, pomodoroID int) {
+	var (
+		pomodoro *Pomodoro
+		taskID   int
+	)
+	err := a.store.With(func(tx *sql.Tx) error {
+		read, id, err := a.store.ReadPomodoro(tx, pomodoroID)
+		pomodoro, taskID = read, id
+		return err
+	})
+	if err != nil {
+		storeError(w, err, "pomodoro", pomodoroID)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		TaskID int `json:"task_id"`
+		*Pomodoro
+	}{taskID, pomodoro})
+}
@@ -0,0 +1,283 @@
+package pomo
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+type apiClient struct {
+	t    *testing.T
+	base string
+}
+
+// do sends a request with an optional JSON body and
+// decodes the response into v unless it is nil.
+func (c apiClient) do(method, url, body string, v interface{}) int {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.base+url, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			c.t.Fatalf("%s %s: %s", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func (c apiClient) expect(status int, method, url, body string, v interface{}) {
+	c.t.Helper()
+	if got := c.do(method, url, body, v); got != status {
+		c.t.Fatalf("%s %s: expected status %d, got %d", method, url, status, got)
+	}
+}
+
+func TestAPIServer(t *testing.T) {
+	baseDir, _ := ioutil.TempDir("/tmp", "")
+	defer os.RemoveAll(baseDir)
+	config := &Config{
+		BasePath:   baseDir,
+		DBPath:     path.Join(baseDir, "pomo.db"),
+		SocketPath: path.Join(baseDir, "pomo.sock"),
+	}
+	store, err := NewStore(config.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := InitDB(store); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAPIServer(config, "0.0.0.0:0"); err == nil {
+		t.Fatal("expected listening on other hosts to fail")
+	}
+	api, err := NewAPIServer(config, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.Start()
+	defer api.Stop()
+	client := apiClient{t: t, base: fmt.Sprintf("http://%s", api.Addr())}
+
+	client.expect(http.StatusNotFound, http.MethodGet, "/api/status", "", nil)
+	client.expect(http.StatusConflict, http.MethodPost, "/api/session/pause", "", nil)
+	client.expect(http.StatusMethodNotAllowed, http.MethodPut, "/api/status", "", nil)
+	client.expect(http.StatusBadRequest, http.MethodPost, "/api/tasks", `{"message": ""}`, nil)
+	task := &Task{}
+	client.expect(http.StatusCreated, http.MethodPost, "/api/tasks",
+		`{"message": "Test Task", "tags": ["code"], "n_pomodoros": 2, "duration": "1m"}`, task)
+	if task.ID == 0 || task.Duration != time.Minute || task.State != TaskTodo {
+		t.Fatalf("unexpected task %+v", task)
+	}
+
+	// requests from other pages and hosts are refused
+	req, _ := http.NewRequest(http.MethodPost, client.base+"/api/tasks", strings.NewReader(`{"message": "x"}`))
+	req.Header.Set("Content-Type", "text/plain")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected a text/plain request to be refused, got %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, client.base+"/api/tasks", nil)
+	req.Host = "attacker.example.com"
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a request for another host to be refused, got %v", err)
+	}
+
+	var tasks []*Task
+	client.expect(http.StatusOK, http.MethodGet, "/api/tasks?q=tag:code", "", &tasks)
+	if len(tasks) != 1 || tasks[0].Message != "Test Task" {
+		t.Fatalf("unexpected tasks %v", tasks)
+	}
+	client.expect(http.StatusOK, http.MethodGet, "/api/tasks?q=tag:home", "", &tasks)
+	if len(tasks) != 0 {
+		t.Fatalf("unexpected tasks %v", tasks)
+	}
+	client.expect(http.StatusBadRequest, http.MethodGet, "/api/tasks?limit=-1", "", nil)
+
+	runner, err := NewMockedTaskRunner(task, store, NoopNotifier{}, SystemClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(runner, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop()
+	runner.Start()
+	waitState(t, runner, RUNNING)
+
+	resp, err := http.Get(client.base + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+	stream := bufio.NewReader(resp.Body)
+	nextEvent := func() (string, string) {
+		t.Helper()
+		var name, data string
+		for {
+			line, err := stream.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			case line == "" && name != "":
+				return name, data
+			}
+		}
+	}
+	if name, data := nextEvent(); name != "status" || !strings.Contains(data, `"task_message":"Test Task"`) {
+		t.Fatalf("unexpected first event %s %s", name, data)
+	}
+
+	status := &Status{}
+	client.expect(http.StatusOK, http.MethodGet, "/api/status", "", status)
+	if status.State != RUNNING || status.TaskID != task.ID {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	client.expect(http.StatusOK, http.MethodPost, "/api/session/pause", "", status)
+	if status.State != PAUSED {
+		t.Fatalf("expected the session to be paused, got %s", status.State)
+	}
+	if name, _ := nextEvent(); name != string(EventPaused) {
+		t.Fatalf("expected a paused event, got %s", name)
+	}
+	client.expect(http.StatusConflict, http.MethodPost, "/api/session/pause", "", nil)
+	client.expect(http.StatusNotFound, http.MethodPost, "/api/session/subscribe", "", nil)
+	client.expect(http.StatusOK, http.MethodPost, "/api/session/extend", `{"count": 2}`, status)
+	if status.NPomodoros != 4 {
+		t.Fatalf("expected 4 pomodoros after extending, got %d", status.NPomodoros)
+	}
+	client.expect(http.StatusConflict, http.MethodPost, fmt.Sprintf("/api/tasks/%d/begin", task.ID), "", nil)
+	resp, err = http.Get(client.base + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != MetricsContentType || !strings.Contains(string(metrics), `pomo_state{state="paused"} 1`) {
+		t.Fatalf("unexpected metrics %s", metrics)
+	}
+	client.expect(http.StatusOK, http.MethodPost, "/api/session/stop", "", status)
+	<-runner.Done()
+	for {
+		name, _ := nextEvent()
+		if name == string(EventSessionComplete) {
+			break
+		}
+	}
+
+	var pomodoros []*Pomodoro
+	client.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/api/tasks/%d/pomodoros", task.ID), "", &pomodoros)
+	if len(pomodoros) != 1 || pomodoros[0].Status != PomodoroAbandoned {
+		t.Fatalf("expected an abandoned pomodoro, got %v", pomodoros)
+	}
+	pomodoro := struct {
+		TaskID int `json:"task_id"`
+		Pomodoro
+	}{}
+	client.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/api/pomodoros/%d", pomodoros[0].ID), "", &pomodoro)
+	if pomodoro.TaskID != task.ID || len(pomodoro.Pauses) != 1 {
+		t.Fatalf("unexpected pomodoro %+v", pomodoro)
+	}
+
+	url := fmt.Sprintf("/api/tasks/%d", task.ID)
+	client.expect(http.StatusBadRequest, http.MethodPut, url+"/state", `{"state": "finished"}`, nil)
+	client.expect(http.StatusOK, http.MethodPut, url+"/state", `{"state": "archived"}`, task)
+	if task.State != TaskArchived {
+		t.Fatalf("expected the task to be archived, got %s", task.State)
+	}
+	client.expect(http.StatusNoContent, http.MethodDelete, url, "", nil)
+	client.expect(http.StatusNotFound, http.MethodGet, url, "", nil)
+	client.expect(http.StatusNotFound, http.MethodDelete, url, "", nil)
+}
+
+func TestAPIServerStop(t *testing.T) {
+	runner, store, _ := initTestRunner(t, &Task{
+		Duration:   25 * time.Minute,
+		NPomodoros: 1,
+		Message:    "Test Task",
+	})
+	defer store.Close()
+	baseDir := t.TempDir()
+	config := &Config{
+		BasePath:   baseDir,
+		DBPath:     path.Join(baseDir, "pomo.db"),
+		SocketPath: path.Join(baseDir, "pomo.sock"),
+	}
+	// the api reads tasks from its own database
+	apiStore, err := NewStore(config.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := InitDB(apiStore); err != nil {
+		t.Fatal(err)
+	}
+	apiStore.Close()
+	server, err := NewServer(runner, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop()
+	runner.Start()
+	waitState(t, runner, RUNNING)
+	api, err := NewAPIServer(config, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.Start()
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/api/events", api.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	stream := bufio.NewReader(resp.Body)
+	if line, err := stream.ReadString('\n'); err != nil || line != "event: status\n" {
+		t.Fatalf("expected the status event, got %q %v", line, err)
+	}
+	// the event stream ends instead of holding up Stop
+	stopped := make(chan struct{})
+	go func() {
+		api.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(apiShutdownTimeout / 2):
+		t.Fatal("stopping the api waited for the event stream")
+	}
+	if _, err := ioutil.ReadAll(stream); err != nil {
+		t.Fatalf("expected the event stream to end, got %v", err)
+	}
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	<-runner.Done()
+}
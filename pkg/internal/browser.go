@@ -0,0 +1,412 @@
+package pomo
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	ui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
+)
+
+// BrowserAction is chosen in the task browser and carried
+// out by the caller once the terminal has been released.
+type BrowserAction int
+
+const (
+	BrowserQuit BrowserAction = iota
+	BrowserBegin
+	BrowserEdit
+)
+
+// Browser is a full screen view of the task history with a
+// filter, a detail pane for the selected task and key bindings
+// to begin, edit, delete or archive it.
+type Browser struct {
+	config *Config
+	store  *Store
+	tasks  []*Task
+	// selected is the index of the selected task
+	// and offset the index of the first visible one
+	selected int
+	offset   int
+	// selectedID is the ID of the last selected task which is
+	// selected again once it matches the filter being typed
+	selectedID int
+	filter     string
+	// filtering is set while the filter is being typed
+	filtering bool
+	// confirm is set while waiting for a task
+	// deletion to be confirmed
+	confirm bool
+	message string
+
+	filterBox *widgets.Paragraph
+	table     *widgets.Table
+	detail    *widgets.Paragraph
+	help      *widgets.Paragraph
+}
+
+func NewBrowser(config *Config, store *Store) *Browser {
+	b := &Browser{
+		config:    config,
+		store:     store,
+		filterBox: widgets.NewParagraph(),
+		table:     widgets.NewTable(),
+		detail:    widgets.NewParagraph(),
+		help:      widgets.NewParagraph(),
+	}
+	b.filterBox.Title = "Filter"
+	b.table.Title = "Tasks"
+	b.table.RowSeparator = false
+	b.table.TextAlignment = ui.AlignLeft
+	b.detail.Title = "Task"
+	b.help.Border = false
+	return b
+}
+
+// Run shows the browser until a task is chosen to be begun
+// or edited or the user quits, it may be called again to
+// return to the browser with the same filter and selection.
+func (b *Browser) Run() (BrowserAction, *Task, error) {
+	err := b.load()
+	if err != nil {
+		return BrowserQuit, nil, err
+	}
+	err = ui.Init()
+	if err != nil {
+		return BrowserQuit, nil, err
+	}
+	defer ui.Close()
+	b.resize()
+	b.render()
+	for e := range ui.PollEvents() {
+		if e.ID == "<Resize>" {
+			b.resize()
+			ui.Clear()
+		}
+		action, closed, err := b.handle(e)
+		if err != nil {
+			b.message = err.Error()
+		}
+		if closed {
+			return action, b.Selected(), nil
+		}
+		b.render()
+	}
+	return BrowserQuit, nil, nil
+}
+
+// Selected returns the selected task or nil if there are no tasks
+func (b *Browser) Selected() *Task {
+	if b.selected < len(b.tasks) {
+		return b.tasks[b.selected]
+	}
+	return nil
+}
+
+// load reads the tasks matching the filter
+// keeping the selected task if possible.
+func (b *Browser) load() error {
+	query, err := ParseFilter(b.filter)
+	if err != nil {
+		return err
+	}
+	if task := b.Selected(); task != nil {
+		b.selectedID = task.ID
+	}
+	err = b.store.With(func(tx *sql.Tx) error {
+		b.tasks, err = b.store.QueryTasks(tx, query)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for i, task := range b.tasks {
+		if task.ID == b.selectedID {
+			b.selected = i
+			return nil
+		}
+	}
+	// the selected task was removed, select the next one
+	b.move(0)
+	return nil
+}
+
+// ParseFilter reads the filter typed in the browser, words of the form
+// tag:NAME, project:NAME or state:STATE filter by those fields and the
+// remaining words must appear in the message. Archived tasks are only
+// shown when filtering by state.
+func ParseFilter(filter string) (TaskQuery, error) {
+	query := TaskQuery{Descending: true}
+	var words []string
+	for _, word := range strings.Fields(filter) {
+		split := strings.SplitN(word, ":", 2)
+		if len(split) != 2 {
+			words = append(words, word)
+			continue
+		}
+		if split[1] == "" {
+			// the value is still being typed
+			continue
+		}
+		switch split[0] {
+		case "tag":
+			query.Tags = append(query.Tags, split[1])
+		case "project":
+			query.Project = split[1]
+		case "state":
+			query.States = append(query.States, TaskState(split[1]))
+		default:
+			words = append(words, word)
+		}
+	}
+	if len(query.States) == 0 {
+		query.States = []TaskState{TaskTodo, TaskActive, TaskDone}
+	}
+	query.Grep = strings.Join(words, " ")
+	_, _, err := query.where()
+	return query, err
+}
+
+// handle applies a key press, returning true along with the
+// action to carry out if the browser should be closed.
+func (b *Browser) handle(e ui.Event) (BrowserAction, bool, error) {
+	if b.filtering {
+		b.message = ""
+		switch e.ID {
+		case "<C-c>":
+			return BrowserQuit, true, nil
+		case "<Enter>", "<Escape>":
+			b.filtering = false
+			return BrowserQuit, false, nil
+		case "<Backspace>", "<C-<Backspace>>":
+			if len(b.filter) > 0 {
+				runes := []rune(b.filter)
+				b.filter = string(runes[:len(runes)-1])
+			}
+		case "<Space>":
+			b.filter += " "
+		default:
+			if strings.HasPrefix(e.ID, "<") {
+				return BrowserQuit, false, nil
+			}
+			b.filter += e.ID
+		}
+		return BrowserQuit, false, b.load()
+	}
+	if b.confirm {
+		b.confirm = false
+		b.message = ""
+		task := b.Selected()
+		if e.ID != "y" || task == nil {
+			return BrowserQuit, false, nil
+		}
+		err := b.store.With(func(tx *sql.Tx) error {
+			return b.store.DeleteTask(tx, task.ID)
+		})
+		if err != nil {
+			return BrowserQuit, false, err
+		}
+		b.message = fmt.Sprintf("deleted task %d", task.ID)
+		return BrowserQuit, false, b.load()
+	}
+	b.message = ""
+	switch e.ID {
+	case "q", "<C-c>":
+		return BrowserQuit, true, nil
+	case "j", "<Down>":
+		b.move(1)
+	case "k", "<Up>":
+		b.move(-1)
+	case "<PageDown>", "<C-d>":
+		b.move(b.visible())
+	case "<PageUp>", "<C-u>":
+		b.move(-b.visible())
+	case "g", "<Home>":
+		b.move(-len(b.tasks))
+	case "G", "<End>":
+		b.move(len(b.tasks))
+	case "/":
+		b.filtering = true
+	case "b", "<Enter>":
+		if b.Selected() != nil {
+			return BrowserBegin, true, nil
+		}
+	case "e":
+		if b.Selected() != nil {
+			return BrowserEdit, true, nil
+		}
+	case "d":
+		if task := b.Selected(); task != nil {
+			b.confirm = true
+			b.message = fmt.Sprintf("delete task %d? [y/n]", task.ID)
+		}
+	case "a":
+		task := b.Selected()
+		if task == nil {
+			return BrowserQuit, false, nil
+		}
+		err := b.store.With(func(tx *sql.Tx) error {
+			return b.store.SetTaskState(tx, task.ID, TaskArchived, time.Now())
+		})
+		if err != nil {
+			return BrowserQuit, false, err
+		}
+		b.message = fmt.Sprintf("archived task %d", task.ID)
+		return BrowserQuit, false, b.load()
+	}
+	return BrowserQuit, false, nil
+}
+
+// move changes the selected task by n rows
+func (b *Browser) move(n int) {
+	b.selected += n
+	if b.selected >= len(b.tasks) {
+		b.selected = len(b.tasks) - 1
+	}
+	if b.selected < 0 {
+		b.selected = 0
+	}
+}
+
+// visible returns the number of task rows
+// that fit in the table below its header.
+func (b *Browser) visible() int {
+	n := b.table.Inner.Dy() - 1
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (b *Browser) resize() {
+	width, height := ui.TerminalDimensions()
+	split := width * 3 / 5
+	b.filterBox.SetRect(0, 0, width, 3)
+	b.table.SetRect(0, 3, split, height-1)
+	b.detail.SetRect(split, 3, width, height-1)
+	b.help.SetRect(0, height-1, width, height)
+	// the message takes the rest of the
+	// table less its borders and separators
+	message := split - 2 - 5 - 8 - 16 - 7 - 4
+	if message < 10 {
+		message = 10
+	}
+	b.table.ColumnWidths = []int{5, 8, 16, 7, message}
+}
+
+func (b *Browser) render() {
+	b.filterBox.Text = b.filter
+	b.filterBox.BorderStyle.Fg = ui.ColorWhite
+	if b.filtering {
+		b.filterBox.Text += "_"
+		b.filterBox.BorderStyle.Fg = ui.ColorGreen
+	}
+	// keep the selected task within the visible rows
+	if b.selected < b.offset {
+		b.offset = b.selected
+	}
+	if b.selected >= b.offset+b.visible() {
+		b.offset = b.selected - b.visible() + 1
+	}
+	end := b.offset + b.visible()
+	if end > len(b.tasks) {
+		end = len(b.tasks)
+	}
+	b.table.Rows = append([][]string{{"ID", "STATE", "STARTED", "POMOS", "MESSAGE"}},
+		taskRows(b.config, b.tasks[b.offset:end])...)
+	b.table.RowStyles = map[int]ui.Style{0: ui.NewStyle(ui.ColorWhite, ui.ColorClear, ui.ModifierBold)}
+	if len(b.tasks) > 0 {
+		b.table.RowStyles[b.selected-b.offset+1] = ui.NewStyle(ui.ColorBlack, ui.ColorWhite)
+	}
+	b.table.Title = fmt.Sprintf("Tasks (%d)", len(b.tasks))
+	b.table.FillRow = true
+	b.detail.Text = ""
+	if task := b.Selected(); task != nil {
+		b.detail.Text = taskDetail(b.config, task)
+	}
+	b.help.Text = "[/] filter [b] begin [e] edit [a] archive [d] delete [q] quit"
+	if b.message != "" {
+		b.help.Text = b.message
+	}
+	ui.Render(b.filterBox, b.table, b.detail, b.help)
+}
+
+// taskRows returns a table row for each task
+func taskRows(config *Config, tasks []*Task) [][]string {
+	rows := [][]string{}
+	for _, task := range tasks {
+		var start string
+		if len(task.Pomodoros) > 0 {
+			start = task.Pomodoros[0].Start.Format(config.DateTimeFmt)
+		}
+		message := task.Message
+		for _, tag := range task.Tags {
+			message += " " + colorTag(config, tag)
+		}
+		rows = append(rows, []string{
+			fmt.Sprint(task.ID),
+			string(task.State),
+			start,
+			fmt.Sprintf("%d/%d", task.Completed(), task.NPomodoros),
+			message,
+		})
+	}
+	return rows
+}
+
+// taskDetail describes a task and each of its pomodoros
+func taskDetail(config *Config, task *Task) string {
+	lines := []string{
+		task.Message,
+		"",
+		fmt.Sprintf("ID:        %d", task.ID),
+		fmt.Sprintf("State:     %s", task.State),
+		fmt.Sprintf("Estimate:  %d x %s", task.NPomodoros, task.Duration),
+		fmt.Sprintf("Actual:    %.1f", task.Actual()),
+	}
+	if task.Project != "" {
+		lines = append(lines, fmt.Sprintf("Project:   %s", task.Project))
+	}
+	if task.ParentID != 0 {
+		lines = append(lines, fmt.Sprintf("Parent:    %d", task.ParentID))
+	}
+	if len(task.Tags) > 0 {
+		tags := make([]string, len(task.Tags))
+		for i, tag := range task.Tags {
+			tags[i] = colorTag(config, tag)
+		}
+		lines = append(lines, fmt.Sprintf("Tags:      %s", strings.Join(tags, " ")))
+	}
+	lines = append(lines, "", fmt.Sprintf("Pomodoros (%d):", len(task.Pomodoros)))
+	for _, pomodoro := range task.Pomodoros {
+		status := string(pomodoro.Status)
+		switch pomodoro.Status {
+		case PomodoroCompleted:
+			status = fmt.Sprintf("[%s](fg:green)", status)
+		case PomodoroInterrupted:
+			status = fmt.Sprintf("[%s](fg:magenta)", status)
+		}
+		line := fmt.Sprintf("%d: %s %s %s", pomodoro.ID,
+			pomodoro.Start.Format(config.DateTimeFmt), pomodoro.Worked().Truncate(time.Second), status)
+		if paused := pomodoro.Paused(); paused > 0 {
+			line += fmt.Sprintf(" (paused %s)", paused.Truncate(time.Second))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// colorTag styles the tag with its configured color
+func colorTag(config *Config, tag string) string {
+	if config.Colors == nil {
+		return tag
+	}
+	name := strings.TrimPrefix(config.Colors.Name(tag), "hi")
+	if _, ok := ui.StyleParserColorMap[name]; !ok {
+		return tag
+	}
+	return fmt.Sprintf("[%s](fg:%s)", tag, name)
+}
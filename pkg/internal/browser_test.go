@@ -0,0 +1,114 @@
+package pomo
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	ui "github.com/gizak/termui/v3"
+)
+
+func TestBrowser(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	for _, task := range []*Task{
+		{Message: "alpha"},
+		{Message: "beta", Tags: []string{"code"}},
+		{Message: "gamma", Tags: []string{"code"}},
+	} {
+		createTestTask(t, store, task)
+	}
+	config := &Config{DateTimeFmt: defaultDateTimeFmt, Colors: &ColorMap{}}
+	if err := json.Unmarshal([]byte(`{"code": "hiblue"}`), config.Colors); err != nil {
+		t.Fatal(err)
+	}
+	browser := NewBrowser(config, store)
+	if err := browser.load(); err != nil {
+		t.Fatal(err)
+	}
+	press := func(keys ...string) (BrowserAction, bool) {
+		t.Helper()
+		var (
+			action BrowserAction
+			closed bool
+			err    error
+		)
+		for _, key := range keys {
+			action, closed, err = browser.handle(ui.Event{ID: key})
+			// partially typed filters may be invalid
+			if err != nil && !browser.filtering {
+				t.Fatal(err)
+			}
+		}
+		return action, closed
+	}
+	selected := func(expected int) {
+		t.Helper()
+		if task := browser.Selected(); task == nil || task.ID != expected {
+			t.Fatalf("expected task %d to be selected, got %v", expected, task)
+		}
+	}
+
+	// newest tasks are listed first
+	selected(3)
+	press("j", "j", "j")
+	selected(1)
+	press("k")
+	selected(2)
+
+	// the selection is kept while filtering
+	press("/", "t", "a", "g", ":", "c", "o", "d", "e", "<Space>", "a", "<Enter>")
+	if len(browser.tasks) != 2 || browser.filter != "tag:code a" {
+		t.Fatalf("unexpected tasks %v for filter %q", browser.tasks, browser.filter)
+	}
+	selected(2)
+	if action, closed := press("q"); !closed || action != BrowserQuit {
+		t.Fatal("expected q to close the browser")
+	}
+	if action, closed := press("e"); !closed || action != BrowserEdit {
+		t.Fatal("expected e to edit the selected task")
+	}
+
+	press("a")
+	selected(3)
+	press("d", "n")
+	selected(3)
+	press("d", "y")
+	if len(browser.tasks) != 0 || browser.Selected() != nil {
+		t.Fatalf("expected no tasks left, got %v", browser.tasks)
+	}
+	press("/")
+	for range browser.filter {
+		press("<Backspace>")
+	}
+	press("<Escape>")
+	if browser.filter != "" || len(browser.tasks) != 1 {
+		t.Fatalf("expected only task 1 without a filter, got %v", browser.tasks)
+	}
+	press("/", "s", "t", "a", "t", "e", ":", "a", "r", "c", "h", "i", "v", "e", "d", "<Enter>")
+	selected(2)
+
+	rows := taskRows(config, browser.tasks)
+	if len(rows) != 1 || rows[0][1] != "archived" || rows[0][4] != "beta [code](fg:blue)" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+	if detail := taskDetail(config, browser.Selected()); !strings.Contains(detail, "Estimate:  0 x 0s") {
+		t.Fatalf("unexpected detail %q", detail)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	query, err := ParseFilter("tag:a write project:web tag:b code state:todo state:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(query.Tags, ",") != "a,b" || query.Project != "web" || query.Grep != "write code" {
+		t.Fatalf("unexpected query %+v", query)
+	}
+	if len(query.States) != 1 || query.States[0] != TaskTodo {
+		t.Fatalf("unexpected states %v", query.States)
+	}
+	if _, err = ParseFilter("state:bogus"); err == nil {
+		t.Fatal("expected an unknown state to fail")
+	}
+}
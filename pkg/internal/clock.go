@@ -0,0 +1,172 @@
+package pomo
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and timers to
+// the TaskRunner so it can be tested deterministically.
+type Clock interface {
+	Now() time.Time
+	Since(time.Time) time.Duration
+	NewTimer(time.Duration) Timer
+	NewTicker(time.Duration) Ticker
+}
+
+// Timer is the subset of time.Timer used by pomo
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(time.Duration) bool
+}
+
+// Ticker is the subset of time.Ticker used by pomo
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is backed by the time package
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                  { return time.Now() }
+func (systemClock) Since(t time.Time) time.Duration { return time.Since(t) }
+func (systemClock) NewTimer(d time.Duration) Timer  { return systemTimer{time.NewTimer(d)} }
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTimer struct{ *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+type systemTicker struct{ *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// FakeClock only moves forward when advanced, firing
+// any timers and tickers that have come due.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	w := c.add(d, 0)
+	return &fakeTimer{w}
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	return &fakeTicker{c.add(d, d)}
+}
+
+// Advance moves the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.fire()
+}
+
+func (c *FakeClock) add(d, period time.Duration) *fakeWaiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &fakeWaiter{
+		clock:    c,
+		ch:       make(chan time.Time, 1),
+		deadline: c.now.Add(d),
+		period:   period,
+		active:   true,
+	}
+	c.waiters = append(c.waiters, w)
+	c.fire()
+	return w
+}
+
+// fire sends on every waiter that has come due,
+// the caller must hold the lock.
+func (c *FakeClock) fire() {
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if !w.active {
+			// stopped timers are added again on reset
+			continue
+		}
+		if !w.deadline.After(c.now) {
+			select {
+			case w.ch <- c.now:
+			default:
+			}
+			if w.period == 0 {
+				w.active = false
+				continue
+			}
+			// skip any ticks that were missed
+			for !w.deadline.After(c.now) {
+				w.deadline = w.deadline.Add(w.period)
+			}
+		}
+		waiters = append(waiters, w)
+	}
+	c.waiters = waiters
+}
+
+type fakeWaiter struct {
+	clock    *FakeClock
+	ch       chan time.Time
+	deadline time.Time
+	period   time.Duration
+	active   bool
+}
+
+func (w *fakeWaiter) stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	active := w.active
+	w.active = false
+	return active
+}
+
+type fakeTimer struct{ *fakeWaiter }
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool { return t.stop() }
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	active := t.active
+	if !active {
+		c.waiters = append(c.waiters, t.fakeWaiter)
+	}
+	t.active = true
+	t.deadline = c.now.Add(d)
+	c.fire()
+	return active
+}
+
+type fakeTicker struct{ *fakeWaiter }
+
+func (t *fakeTicker) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTicker) Stop() { t.stop() }
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"time"
 
 	"github.com/adrg/xdg"
 	"github.com/fatih/color"
@@ -13,6 +14,9 @@ import (
 
 const (
 	defaultDateTimeFmt = "2006-01-02 15:04"
+	defaultShortBreak  = 5 * time.Minute
+	defaultLongBreak   = 15 * time.Minute
+	defaultLongEvery   = 4
 )
 
 // Config represents user preferences
@@ -23,7 +27,23 @@ type Config struct {
 	DBPath      string    `json:"dbPath"`
 	SocketPath  string    `json:"socketPath"`
 	IconPath    string    `json:"iconPath"`
-	OnEvent     []string  `json:"onEvent"`
+	// OnEvent is run each time the state of the
+	// session changes, it is superseded by Hooks
+	OnEvent []string `json:"onEvent"`
+	// Hooks are commands run on session events
+	Hooks []Hook `json:"hooks"`
+	// Webhooks are sent session events, undelivered
+	// events are kept in the outbox in BasePath
+	Webhooks []Webhook `json:"webhooks"`
+	// Notifiers are sent every notification, the
+	// Xnotifier is used when none are configured
+	Notifiers []NotifierConfig `json:"notifiers"`
+	// PIDPath is written while pomo runs as a daemon
+	PIDPath string `json:"pidPath"`
+	// ConfigPath is the file this config was loaded from
+	ConfigPath string `json:"-"`
+	// Breaks configures the rest taken between pomodoros
+	Breaks *Breaks `json:"breaks"`
 	// Publish pushes updates to the configured
 	// SocketPath rather than listening for requests
 	Publish bool `json:"publish"`
@@ -32,6 +52,88 @@ type Config struct {
 	PublishJson bool `json:"publishJson"`
 	// If Publish is true, provide a socket path to publish to
 	PublishSocketPath string `json:"publishSocketPath"`
+	// APIListen serves the HTTP API on a loopback
+	// host:port or a Unix socket given as unix:PATH
+	APIListen string `json:"apiListen"`
+	// MetricsPath is rewritten with Prometheus metrics while a
+	// session runs for the textfile collector of node_exporter
+	MetricsPath string `json:"metricsPath"`
+}
+
+// Breaks describes the length of the breaks taken
+// between pomodoros, a long break is taken after
+// every LongEvery pomodoros.
+type Breaks struct {
+	Short     time.Duration
+	Long      time.Duration
+	LongEvery int
+	// AutoStart begins the next pomodoro as
+	// soon as the break has ended
+	AutoStart bool
+}
+
+type breaksJSON struct {
+	Short     string `json:"short"`
+	Long      string `json:"long"`
+	LongEvery int    `json:"longEvery"`
+	AutoStart bool   `json:"autoStart"`
+}
+
+// Length returns the planned break after
+// completing count pomodoros.
+func (b Breaks) Length(count int) time.Duration {
+	if b.LongEvery > 0 && count > 0 && count%b.LongEvery == 0 {
+		return b.Long
+	}
+	return b.Short
+}
+
+func (b *Breaks) MarshalJSON() ([]byte, error) {
+	return json.Marshal(breaksJSON{
+		Short:     b.Short.String(),
+		Long:      b.Long.String(),
+		LongEvery: b.LongEvery,
+		AutoStart: b.AutoStart,
+	})
+}
+
+func (b *Breaks) UnmarshalJSON(raw []byte) error {
+	// omitted fields keep their default value
+	parsed := breaksJSON{LongEvery: defaultLongEvery}
+	err := json.Unmarshal(raw, &parsed)
+	if err != nil {
+		return err
+	}
+	breaks := &Breaks{
+		Short:     defaultShortBreak,
+		Long:      defaultLongBreak,
+		LongEvery: parsed.LongEvery,
+		AutoStart: parsed.AutoStart,
+	}
+	if parsed.Short != "" {
+		breaks.Short, err = time.ParseDuration(parsed.Short)
+		if err != nil {
+			return err
+		}
+	}
+	if parsed.Long != "" {
+		breaks.Long, err = time.ParseDuration(parsed.Long)
+		if err != nil {
+			return err
+		}
+	}
+	*b = *breaks
+	return nil
+}
+
+// DefaultBreaks returns the classic 5m short break
+// with a 15m long break after every 4 pomodoros.
+func DefaultBreaks() *Breaks {
+	return &Breaks{
+		Short:     defaultShortBreak,
+		Long:      defaultLongBreak,
+		LongEvery: defaultLongEvery,
+	}
 }
 
 type ColorMap struct {
@@ -46,6 +148,15 @@ func (c *ColorMap) Get(name string) *color.Color {
 	return nil
 }
 
+// Name returns the name of the color configured
+// for the tag or an empty string if there is none.
+func (c *ColorMap) Name(tag string) string {
+	if _, ok := c.colors[tag]; ok {
+		return c.tags[tag]
+	}
+	return ""
+}
+
 func (c *ColorMap) MarshalJSON() ([]byte, error) {
 	return json.Marshal(c.tags)
 }
@@ -86,6 +197,35 @@ func (c *ColorMap) UnmarshalJSON(raw []byte) error {
 	return nil
 }
 
+// redactedSecret replaces secrets in printed configs
+const redactedSecret = "********"
+
+// Redacted returns a copy of the config which
+// is safe to print, webhook secrets are masked.
+func (c Config) Redacted() Config {
+	if len(c.Webhooks) > 0 {
+		webhooks := make([]Webhook, len(c.Webhooks))
+		for i, webhook := range c.Webhooks {
+			if webhook.Secret != "" {
+				webhook.Secret = redactedSecret
+			}
+			webhooks[i] = webhook
+		}
+		c.Webhooks = webhooks
+	}
+	return c
+}
+
+// hooks returns the configured hooks
+// including the legacy OnEvent command.
+func (c *Config) hooks() []Hook {
+	hooks := append([]Hook{}, c.Hooks...)
+	if len(c.OnEvent) > 0 {
+		hooks = append(hooks, legacyHook(c.OnEvent))
+	}
+	return hooks
+}
+
 func LoadConfig(configPath string, config *Config) error {
 	raw, err := ioutil.ReadFile(configPath)
 	if err != nil {
@@ -106,9 +246,13 @@ func LoadConfig(configPath string, config *Config) error {
 	if err != nil {
 		return err
 	}
+	config.ConfigPath = configPath
 	if config.DateTimeFmt == "" {
 		config.DateTimeFmt = defaultDateTimeFmt
 	}
+	if config.Breaks == nil {
+		config.Breaks = DefaultBreaks()
+	}
 	if config.BasePath == "" {
 		config.BasePath = path.Dir(configPath)
 		err := os.MkdirAll(config.BasePath, os.ModePerm)
@@ -130,6 +274,9 @@ func LoadConfig(configPath string, config *Config) error {
 			return err
 		}
 	}
+	if config.PIDPath == "" {
+		config.PIDPath = path.Join(xdg.RuntimeDir, "pomo.pid")
+	}
 	if config.IconPath == "" {
 		config.IconPath = path.Join(xdg.DataHome, "pomo", "icon.png")
 		err := os.MkdirAll(path.Dir(config.IconPath), os.ModePerm)
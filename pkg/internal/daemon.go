@@ -0,0 +1,109 @@
+package pomo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// RunDaemon runs a task session without a user interface
+// until it completes or the process receives SIGINT or
+// SIGTERM, in which case the partial pomodoro is recorded.
+func RunDaemon(runner *TaskRunner, config *Config) error {
+	err := writePIDFile(config.PIDPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(config.PIDPath)
+	server, err := NewServer(runner, config)
+	if err != nil {
+		return err
+	}
+	server.Start()
+	defer server.Stop()
+	defer ServeAPI(config)()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	runner.Start()
+	select {
+	case <-runner.Done():
+	case sig := <-signals:
+		log.Printf("received %s, stopping session", sig)
+		err := runner.Stop()
+		if err != nil && err != ErrSessionConcluded {
+			log.Printf("failed to stop session: %s", err)
+		}
+		<-runner.Done()
+	}
+	return runner.Err()
+}
+
+// Detach starts a new pomo process running the
+// daemon for the given task in the background,
+// optionally restoring it from the last checkpoint.
+func Detach(config *Config, taskID int, restore bool) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return -1, err
+	}
+	logFile, err := os.OpenFile(
+		path.Join(config.BasePath, "daemon.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return -1, err
+	}
+	defer logFile.Close()
+	args := []string{"-p", config.ConfigPath, "daemon"}
+	if restore {
+		args = append(args, "--restore")
+	}
+	cmd := exec.Command(exe, append(args, strconv.Itoa(taskID))...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr()
+	err = cmd.Start()
+	if err != nil {
+		return -1, err
+	}
+	pid := cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
+
+// ReadPID returns the process ID of a running daemon
+func ReadPID(pidPath string) (int, error) {
+	raw, err := ioutil.ReadFile(pidPath)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(raw)))
+}
+
+func writePIDFile(pidPath string) error {
+	if pid, err := ReadPID(pidPath); err == nil && processAlive(pid) {
+		return fmt.Errorf("pomo daemon is already running with pid %d", pid)
+	}
+	err := os.MkdirAll(path.Dir(pidPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// processAlive reports whether a process with the pid exists
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
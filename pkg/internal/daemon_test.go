@@ -0,0 +1,114 @@
+//go:build !windows
+// +build !windows
+
+package pomo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func initTestDaemon(t *testing.T, task *Task) (*TaskRunner, *Store, *FakeClock, *Config) {
+	t.Helper()
+	runner, store, clock := initTestRunner(t, task)
+	baseDir := t.TempDir()
+	config := &Config{
+		BasePath:   baseDir,
+		SocketPath: path.Join(baseDir, "pomo.sock"),
+		PIDPath:    path.Join(baseDir, "pomo.pid"),
+	}
+	return runner, store, clock, config
+}
+
+func runTestDaemon(runner *TaskRunner, config *Config) <-chan error {
+	errs := make(chan error, 1)
+	go func() { errs <- RunDaemon(runner, config) }()
+	return errs
+}
+
+func waitDaemon(t *testing.T, errs <-chan error) {
+	t.Helper()
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon did not exit")
+	}
+}
+
+func TestRunDaemonSignal(t *testing.T) {
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGINT} {
+		runner, store, clock, config := initTestDaemon(t, &Task{
+			Duration:   25 * time.Minute,
+			NPomodoros: 2,
+			Message:    "Test Task",
+		})
+		errs := runTestDaemon(runner, config)
+		waitState(t, runner, RUNNING)
+		if pid, err := ReadPID(config.PIDPath); err != nil || pid != os.Getpid() {
+			t.Fatalf("expected the pid file to hold %d, got %d %v", os.Getpid(), pid, err)
+		}
+		clock.Advance(10 * time.Minute)
+		if err := syscall.Kill(os.Getpid(), sig); err != nil {
+			t.Fatal(err)
+		}
+		waitDaemon(t, errs)
+		if _, err := os.Stat(config.PIDPath); !os.IsNotExist(err) {
+			t.Fatalf("%s: expected the pid file to be removed, got %v", sig, err)
+		}
+		task := readTestTask(t, store, runner.taskID)
+		if len(task.Pomodoros) != 1 || task.Pomodoros[0].Status != PomodoroInterrupted ||
+			task.Pomodoros[0].Duration() != 10*time.Minute {
+			t.Fatalf("%s: expected a 10m interrupted pomodoro, got %v", sig, task.Pomodoros)
+		}
+		store.Close()
+	}
+}
+
+func TestRunDaemonPIDFile(t *testing.T) {
+	runner, store, clock, config := initTestDaemon(t, &Task{
+		Duration:   25 * time.Minute,
+		NPomodoros: 1,
+		Message:    "Test Task",
+	})
+	defer store.Close()
+
+	// a daemon is already running as this process
+	ioutil.WriteFile(config.PIDPath, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+	err := RunDaemon(runner, config)
+	if err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Fatalf("expected the daemon to refuse to start, got %v", err)
+	}
+	if pid, err := ReadPID(config.PIDPath); err != nil || pid != os.Getpid() {
+		t.Fatalf("expected the pid file of the running daemon to be kept, got %d %v", pid, err)
+	}
+
+	// the pid file of a daemon that exited is replaced
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(config.PIDPath, []byte(fmt.Sprintf("%d\n", exited.Process.Pid)), 0644)
+	errs := runTestDaemon(runner, config)
+	waitState(t, runner, RUNNING)
+	if pid, err := ReadPID(config.PIDPath); err != nil || pid != os.Getpid() {
+		t.Fatalf("expected the stale pid file to be replaced, got %d %v", pid, err)
+	}
+	clock.Advance(25 * time.Minute)
+	waitDaemon(t, errs)
+	if _, err := os.Stat(config.PIDPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the pid file to be removed, got %v", err)
+	}
+	if task := readTestTask(t, store, runner.taskID); task.State != TaskDone {
+		t.Fatalf("expected the completed task to be done, got %s", task.State)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package pomo
+
+import "syscall"
+
+// detachedProcAttr starts the process in a new session
+// so it is not terminated along with the terminal.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package pomo
+
+import "syscall"
+
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{}
+}
@@ -0,0 +1,153 @@
+package pomo
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// EstimateRow compares the estimated and actual
+// pomodoros of the tasks with a tag finished
+// within a single period.
+type EstimateRow struct {
+	Tag    string `json:"tag"`
+	Period string `json:"period"`
+	Tasks  int    `json:"tasks"`
+	// Estimated is the sum of the pomodoros the tasks
+	// were expected to take and Actual the sum of
+	// the pomodoros they did take.
+	Estimated int     `json:"estimated"`
+	Actual    float64 `json:"actual"`
+	// accuracies is the sum of the accuracy of each task
+	accuracies float64
+}
+
+// Ratio is the actual pomodoros over the estimated ones,
+// a ratio above one means tasks were underestimated.
+func (r EstimateRow) Ratio() float64 {
+	if r.Estimated == 0 {
+		return 0
+	}
+	return r.Actual / float64(r.Estimated)
+}
+
+// Accuracy is the mean accuracy of the tasks where the accuracy
+// of a task is the smaller of its estimate and actual pomodoros
+// divided by the larger, one is a perfect estimate.
+func (r EstimateRow) Accuracy() float64 {
+	if r.Tasks == 0 {
+		return 0
+	}
+	return r.accuracies / float64(r.Tasks)
+}
+
+// MarshalJSON includes the ratio and accuracy
+func (r EstimateRow) MarshalJSON() ([]byte, error) {
+	type row EstimateRow
+	return json.Marshal(struct {
+		row
+		Ratio    float64 `json:"ratio"`
+		Accuracy float64 `json:"accuracy"`
+	}{row(r), r.Ratio(), r.Accuracy()})
+}
+
+func (r *EstimateRow) add(task *Task) {
+	estimated := float64(task.NPomodoros)
+	actual := task.Actual()
+	r.Tasks++
+	r.Estimated += task.NPomodoros
+	r.Actual += actual
+	if larger := math.Max(estimated, actual); larger > 0 {
+		r.accuracies += math.Min(estimated, actual) / larger
+	}
+}
+
+// Estimates summarizes how well the pomodoros of
+// finished tasks were estimated by tag over time.
+type Estimates struct {
+	By    Grouping       `json:"by"`
+	Rows  []*EstimateRow `json:"rows"`
+	Total EstimateRow    `json:"total"`
+}
+
+// NewEstimates compares the estimate of each task finished at or
+// after since with the pomodoros it took, grouped by tag and by
+// the day, week or month it was finished.
+func NewEstimates(tasks []*Task, by Grouping, since time.Time) (*Estimates, error) {
+	switch by {
+	case ByDay, ByWeek, ByMonth:
+	default:
+		return nil, fmt.Errorf("cannot group estimates by %q", by)
+	}
+	estimates := &Estimates{By: by, Total: EstimateRow{Tag: "total"}}
+	rows := map[string]*EstimateRow{}
+	for _, task := range tasks {
+		if task.Finished == nil || task.Finished.Before(since) || task.NPomodoros < 1 {
+			continue
+		}
+		estimates.Total.add(task)
+		tags := task.Tags
+		if len(tags) == 0 {
+			tags = []string{ungrouped}
+		}
+		period := by.key(*task.Finished)
+		for _, tag := range tags {
+			key := tag + "\x00" + period
+			if _, ok := rows[key]; !ok {
+				rows[key] = &EstimateRow{Tag: tag, Period: period}
+				estimates.Rows = append(estimates.Rows, rows[key])
+			}
+			rows[key].add(task)
+		}
+	}
+	sort.Slice(estimates.Rows, func(i, j int) bool {
+		if estimates.Rows[i].Tag != estimates.Rows[j].Tag {
+			return estimates.Rows[i].Tag < estimates.Rows[j].Tag
+		}
+		return estimates.Rows[i].Period < estimates.Rows[j].Period
+	})
+	return estimates, nil
+}
+
+// WriteText writes the estimates as an aligned table
+func (e Estimates) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "TAG\tPERIOD\tTASKS\tESTIMATED\tACTUAL\tRATIO\tACCURACY\n")
+	for _, row := range append(e.Rows, &e.Total) {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.1f\t%.2f\t%.0f%%\n",
+			row.Tag,
+			row.Period,
+			row.Tasks,
+			row.Estimated,
+			row.Actual,
+			row.Ratio(),
+			row.Accuracy()*100,
+		)
+	}
+	return tw.Flush()
+}
+
+// WriteCSV writes the estimates with a header row
+func (e Estimates) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"tag", string(e.By), "tasks", "estimated", "actual", "ratio", "accuracy"})
+	for _, row := range append(e.Rows, &e.Total) {
+		writer.Write([]string{
+			row.Tag,
+			row.Period,
+			strconv.Itoa(row.Tasks),
+			strconv.Itoa(row.Estimated),
+			strconv.FormatFloat(row.Actual, 'f', 2, 64),
+			strconv.FormatFloat(row.Ratio(), 'f', 2, 64),
+			strconv.FormatFloat(row.Accuracy(), 'f', 2, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
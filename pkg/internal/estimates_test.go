@@ -0,0 +1,73 @@
+package pomo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimates(t *testing.T) {
+	day := time.Date(2022, 1, 3, 9, 0, 0, 0, time.Local)
+	nextMonth := day.AddDate(0, 1, 0)
+	pomodoros := func(n int, status PomodoroStatus) []*Pomodoro {
+		var pomodoros []*Pomodoro
+		for i := 0; i < n; i++ {
+			start := day.Add(time.Duration(i) * time.Hour)
+			pomodoros = append(pomodoros, &Pomodoro{Start: start, End: start.Add(25 * time.Minute), Status: status})
+		}
+		return pomodoros
+	}
+	tasks := []*Task{
+		// underestimated by half
+		{NPomodoros: 2, Duration: 25 * time.Minute, Tags: []string{"code"}, Finished: &day,
+			Pomodoros: pomodoros(4, PomodoroCompleted)},
+		// finished early, the interrupted pomodoro ran for 5m
+		{NPomodoros: 4, Duration: 25 * time.Minute, Tags: []string{"code", "docs"}, Finished: &nextMonth,
+			Pomodoros: append(pomodoros(1, PomodoroCompleted), &Pomodoro{
+				Start: day, End: day.Add(5 * time.Minute), Status: PomodoroInterrupted,
+			})},
+		{NPomodoros: 1, Duration: 25 * time.Minute, Finished: &day, Pomodoros: pomodoros(1, PomodoroCompleted)},
+		// not finished yet
+		{NPomodoros: 1, Duration: 25 * time.Minute, Pomodoros: pomodoros(3, PomodoroCompleted)},
+	}
+
+	estimates, err := NewEstimates(tasks, ByMonth, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, row := range estimates.Rows {
+		keys = append(keys, row.Tag+" "+row.Period)
+	}
+	if strings.Join(keys, ",") != "- 2022-01,code 2022-01,code 2022-02,docs 2022-02" {
+		t.Fatalf("unexpected rows %v", keys)
+	}
+	code := estimates.Rows[1]
+	if code.Estimated != 2 || code.Actual != 4 || code.Ratio() != 2 || code.Accuracy() != 0.5 {
+		t.Fatalf("unexpected row %+v", code)
+	}
+	if docs := estimates.Rows[3]; docs.Actual != 1.2 || docs.Accuracy() != 0.3 {
+		t.Fatalf("expected 1.2 actual pomodoros, got %+v", docs)
+	}
+	if estimates.Total.Tasks != 3 || estimates.Total.Estimated != 7 {
+		t.Fatalf("unexpected total %+v", estimates.Total)
+	}
+
+	estimates, err = NewEstimates(tasks, ByMonth, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := estimates.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || lines[3] != "total,,1,4,1.20,0.30,0.30" {
+		t.Fatalf("unexpected csv %q", lines)
+	}
+
+	if _, err := NewEstimates(tasks, ByTag, time.Time{}); err == nil {
+		t.Fatal("expected grouping estimates by tag to fail")
+	}
+}
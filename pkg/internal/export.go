@@ -0,0 +1,557 @@
+package pomo
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportFormat is an encoding of task history
+// that can be exported and imported again.
+type ExportFormat string
+
+const (
+	// FormatJSON is lossless and uses the Task JSON encoding
+	FormatJSON ExportFormat = "json"
+	// FormatCSV has a row for each pomodoro, pauses are
+	// reduced to their total and breaks are omitted.
+	FormatCSV ExportFormat = "csv"
+	// FormatICal has a VEVENT for each pomodoro
+	FormatICal ExportFormat = "ical"
+)
+
+// FormatFromPath guesses the format of a file by its extension
+func FormatFromPath(path string) (ExportFormat, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON, nil
+	case ".csv":
+		return FormatCSV, nil
+	case ".ics", ".ical":
+		return FormatICal, nil
+	}
+	return "", fmt.Errorf("cannot determine the format of %s", path)
+}
+
+// ExportTasks writes tasks and their pomodoros in the given format
+func ExportTasks(w io.Writer, format ExportFormat, tasks []*Task) error {
+	switch format {
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(tasks)
+	case FormatCSV:
+		return exportCSV(w, tasks)
+	case FormatICal:
+		return exportICal(w, tasks)
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// ParseTasks reads tasks previously written by ExportTasks,
+// the IDs of the tasks are those of the exporting database.
+func ParseTasks(r io.Reader, format ExportFormat) ([]*Task, error) {
+	switch format {
+	case FormatJSON:
+		tasks := []*Task{}
+		err := json.NewDecoder(r).Decode(&tasks)
+		return tasks, err
+	case FormatCSV:
+		return parseCSV(r)
+	case FormatICal:
+		return parseICal(r)
+	}
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
+
+// csvHeader is the first row of a CSV export,
+// tags are separated by semicolons.
+var csvHeader = []string{
+	"task_id", "message", "tags", "n_pomodoros", "duration",
+	"start", "end", "status", "paused", "state", "project", "parent_id",
+}
+
+// csvMinFields is the number of fields in exports
+// that predate task states and projects.
+const csvMinFields = 9
+
+func exportCSV(w io.Writer, tasks []*Task) error {
+	writer := csv.NewWriter(w)
+	writer.Write(csvHeader)
+	for _, task := range tasks {
+		row := []string{
+			strconv.Itoa(task.ID),
+			task.Message,
+			strings.Join(task.Tags, ";"),
+			strconv.Itoa(task.NPomodoros),
+			task.Duration.String(),
+		}
+		var parentID string
+		if task.ParentID != 0 {
+			parentID = strconv.Itoa(task.ParentID)
+		}
+		if len(task.Pomodoros) == 0 {
+			writer.Write(append(row, "", "", "", "", string(task.State), task.Project, parentID))
+		}
+		for _, pomodoro := range task.Pomodoros {
+			writer.Write(append(row,
+				pomodoro.Start.Format(time.RFC3339Nano),
+				pomodoro.End.Format(time.RFC3339Nano),
+				string(pomodoro.Status),
+				pomodoro.Paused().String(),
+				string(task.State),
+				task.Project,
+				parentID,
+			))
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func parseCSV(r io.Reader) ([]*Task, error) {
+	reader := csv.NewReader(r)
+	// exports that predate task states and projects have fewer columns
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	tasks := []*Task{}
+	var task *Task
+	for i, record := range records {
+		if len(record) < csvMinFields || len(record) > len(csvHeader) {
+			return nil, fmt.Errorf("line %d: expected %d fields, got %d", i+1, len(csvHeader), len(record))
+		}
+		if i == 0 && record[0] == csvHeader[0] {
+			continue
+		}
+		taskID, err := strconv.Atoi(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid task_id: %s", i+1, err)
+		}
+		if task == nil || task.ID != taskID {
+			task = &Task{ID: taskID, Message: record[1], Pomodoros: []*Pomodoro{}}
+			if len(record) > 9 {
+				task.State = TaskState(record[9])
+			}
+			if len(record) > 10 {
+				task.Project = record[10]
+			}
+			if len(record) > 11 && record[11] != "" {
+				task.ParentID, err = strconv.Atoi(record[11])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid parent_id: %s", i+1, err)
+				}
+			}
+			if record[2] != "" {
+				task.Tags = strings.Split(record[2], ";")
+			}
+			task.NPomodoros, err = strconv.Atoi(record[3])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid n_pomodoros: %s", i+1, err)
+			}
+			task.Duration, err = time.ParseDuration(record[4])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid duration: %s", i+1, err)
+			}
+			tasks = append(tasks, task)
+		}
+		if record[5] == "" {
+			// task without any pomodoros
+			continue
+		}
+		pomodoro := &Pomodoro{Status: PomodoroStatus(record[7]), Pauses: []*Pause{}}
+		pomodoro.Start, err = time.Parse(time.RFC3339Nano, record[5])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid start: %s", i+1, err)
+		}
+		pomodoro.End, err = time.Parse(time.RFC3339Nano, record[6])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid end: %s", i+1, err)
+		}
+		if record[8] != "" {
+			paused, err := time.ParseDuration(record[8])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid paused: %s", i+1, err)
+			}
+			if paused > 0 {
+				// only the total is known so it is
+				// recorded as a single final pause
+				pomodoro.Pauses = append(pomodoro.Pauses, &Pause{
+					Start: pomodoro.End.Add(-paused),
+					End:   pomodoro.End,
+				})
+			}
+		}
+		task.Pomodoros = append(task.Pomodoros, pomodoro)
+	}
+	return tasks, nil
+}
+
+const icalTimeFmt = "20060102T150405Z"
+
+// icalEscape escapes text property values per RFC 5545
+var icalEscape = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+var icalUnescape = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+// icalLine folds content lines longer than 75 octets, the
+// space starting each continuation counts towards its length.
+func icalLine(w io.Writer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		// do not split a multi-byte character
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		fmt.Fprintf(w, "%s\r\n ", line[:cut])
+		line = line[cut:]
+		limit = 74
+	}
+	fmt.Fprintf(w, "%s\r\n", line)
+}
+
+func exportICal(w io.Writer, tasks []*Task) error {
+	buf := bufio.NewWriter(w)
+	icalLine(buf, "BEGIN:VCALENDAR")
+	icalLine(buf, "VERSION:2.0")
+	icalLine(buf, "PRODID:-//pomo//pomo//EN")
+	now := time.Now().UTC().Format(icalTimeFmt)
+	for _, task := range tasks {
+		for _, pomodoro := range task.Pomodoros {
+			icalLine(buf, "BEGIN:VEVENT")
+			icalLine(buf, fmt.Sprintf("UID:%d-%d@pomo", task.ID, pomodoro.Start.UnixNano()))
+			icalLine(buf, "DTSTAMP:"+now)
+			icalLine(buf, "DTSTART:"+pomodoro.Start.UTC().Format(icalTimeFmt))
+			icalLine(buf, "DTEND:"+pomodoro.End.UTC().Format(icalTimeFmt))
+			icalLine(buf, "SUMMARY:"+icalEscape.Replace(task.Message))
+			if len(task.Tags) > 0 {
+				tags := make([]string, len(task.Tags))
+				for i, tag := range task.Tags {
+					tags[i] = icalEscape.Replace(tag)
+				}
+				icalLine(buf, "CATEGORIES:"+strings.Join(tags, ","))
+			}
+			icalLine(buf, fmt.Sprintf("X-POMO-TASK-ID:%d", task.ID))
+			icalLine(buf, fmt.Sprintf("X-POMO-N-POMODOROS:%d", task.NPomodoros))
+			icalLine(buf, "X-POMO-DURATION:"+task.Duration.String())
+			icalLine(buf, "X-POMO-STATE:"+string(task.State))
+			if task.Project != "" {
+				icalLine(buf, "X-POMO-PROJECT:"+icalEscape.Replace(task.Project))
+			}
+			if task.ParentID != 0 {
+				icalLine(buf, fmt.Sprintf("X-POMO-PARENT-ID:%d", task.ParentID))
+			}
+			icalLine(buf, "X-POMO-STATUS:"+string(pomodoro.Status))
+			icalLine(buf, "X-POMO-PAUSED:"+pomodoro.Paused().String())
+			icalLine(buf, "END:VEVENT")
+		}
+	}
+	icalLine(buf, "END:VCALENDAR")
+	return buf.Flush()
+}
+
+// splitICalList splits an escaped list value on unescaped commas
+func splitICalList(value string) []string {
+	var (
+		items   []string
+		current strings.Builder
+	)
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value):
+			current.WriteByte(value[i])
+			current.WriteByte(value[i+1])
+			i++
+		case value[i] == ',':
+			items = append(items, icalUnescape.Replace(current.String()))
+			current.Reset()
+		default:
+			current.WriteByte(value[i])
+		}
+	}
+	return append(items, icalUnescape.Replace(current.String()))
+}
+
+// parseICal reads a VEVENT for each pomodoro, events that were not
+// exported by pomo are grouped into tasks by their summary.
+func parseICal(r io.Reader) ([]*Task, error) {
+	scanner := bufio.NewScanner(r)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			// unfold continuation lines
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	tasks := []*Task{}
+	byKey := map[string]*Task{}
+	var (
+		event   map[string]string
+		inEvent bool
+	)
+	for n, line := range lines {
+		switch line {
+		case "BEGIN:VEVENT":
+			event = map[string]string{}
+			inEvent = true
+			continue
+		case "END:VEVENT":
+			inEvent = false
+			err := addICalEvent(event, &tasks, byKey)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n+1, err)
+			}
+			continue
+		}
+		if !inEvent {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		// property parameters such as TZID are ignored
+		name := strings.ToUpper(strings.SplitN(line[:colon], ";", 2)[0])
+		event[name] = line[colon+1:]
+	}
+	return tasks, nil
+}
+
+func parseICalTime(value string) (time.Time, error) {
+	for _, layout := range []string{icalTimeFmt, "20060102T150405"} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date-time %q", value)
+}
+
+func addICalEvent(event map[string]string, tasks *[]*Task, byKey map[string]*Task) error {
+	start, err := parseICalTime(event["DTSTART"])
+	if err != nil {
+		return err
+	}
+	end, err := parseICalTime(event["DTEND"])
+	if err != nil {
+		return err
+	}
+	message := icalUnescape.Replace(event["SUMMARY"])
+	key := event["X-POMO-TASK-ID"] + "\x00" + message
+	task, ok := byKey[key]
+	if !ok {
+		task = &Task{Message: message, Pomodoros: []*Pomodoro{}, Duration: end.Sub(start)}
+		task.ID, _ = strconv.Atoi(event["X-POMO-TASK-ID"])
+		task.State = TaskState(event["X-POMO-STATE"])
+		task.Project = icalUnescape.Replace(event["X-POMO-PROJECT"])
+		task.ParentID, _ = strconv.Atoi(event["X-POMO-PARENT-ID"])
+		if event["CATEGORIES"] != "" {
+			task.Tags = splitICalList(event["CATEGORIES"])
+		}
+		if n, err := strconv.Atoi(event["X-POMO-N-POMODOROS"]); err == nil {
+			task.NPomodoros = n
+		}
+		if duration, err := time.ParseDuration(event["X-POMO-DURATION"]); err == nil {
+			task.Duration = duration
+		}
+		byKey[key] = task
+		*tasks = append(*tasks, task)
+	}
+	pomodoro := &Pomodoro{
+		Start:  start,
+		End:    end,
+		Status: PomodoroStatus(event["X-POMO-STATUS"]),
+		Pauses: []*Pause{},
+	}
+	if pomodoro.Status == "" {
+		pomodoro.Status = PomodoroCompleted
+	}
+	if paused, err := time.ParseDuration(event["X-POMO-PAUSED"]); err == nil && paused > 0 {
+		pomodoro.Pauses = append(pomodoro.Pauses, &Pause{Start: end.Add(-paused), End: end})
+	}
+	task.Pomodoros = append(task.Pomodoros, pomodoro)
+	if _, ok := event["X-POMO-N-POMODOROS"]; !ok {
+		// foreign events plan as many pomodoros as were done
+		task.NPomodoros = len(task.Pomodoros)
+	}
+	return nil
+}
+
+// ImportResult counts how each imported task was handled
+// and maps the exported task IDs to the stored ones.
+type ImportResult struct {
+	Created int
+	Merged  int
+	Skipped int
+	IDs     map[int]int
+}
+
+// pomodoroKey identifies a pomodoro across databases by the
+// second it started as some formats drop sub-second precision.
+func pomodoroKey(pomodoro *Pomodoro) int64 {
+	return pomodoro.Start.Unix()
+}
+
+// ImportTasks stores tasks read from an export under new IDs. A task
+// is a duplicate of a stored one with the same message when they share
+// a pomodoro or neither has any. Duplicates are skipped unless merge is
+// set, in which case their missing tags, pomodoros and breaks are added.
+// Nothing is imported if any of the tasks is invalid.
+func (s Store) ImportTasks(tx *sql.Tx, tasks []*Task, merge bool) (*ImportResult, error) {
+	for _, task := range tasks {
+		err := task.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("task %d: %s", task.ID, err)
+		}
+	}
+	existing, err := s.QueryTasks(tx, TaskQuery{})
+	if err != nil {
+		return nil, err
+	}
+	byMessage := map[string][]*Task{}
+	for _, task := range existing {
+		byMessage[task.Message] = append(byMessage[task.Message], task)
+	}
+	result := &ImportResult{IDs: map[int]int{}}
+	// created tasks which are sub-tasks in the import
+	var children []*Task
+	for _, task := range tasks {
+		duplicate := findDuplicate(byMessage[task.Message], task)
+		if duplicate == nil {
+			// parents are linked once every task has an ID
+			created := *task
+			created.ParentID = 0
+			taskID, err := s.CreateTask(tx, created)
+			if err != nil {
+				return nil, err
+			}
+			for _, pomodoro := range task.Pomodoros {
+				_, err = s.CreatePomodoro(tx, taskID, *pomodoro)
+				if err != nil {
+					return nil, err
+				}
+			}
+			for _, b := range task.Breaks {
+				err = s.CreateBreak(tx, taskID, *b)
+				if err != nil {
+					return nil, err
+				}
+			}
+			result.IDs[task.ID] = taskID
+			result.Created++
+			if task.ParentID != 0 {
+				children = append(children, task)
+			}
+			stored := *task
+			stored.ID = taskID
+			byMessage[task.Message] = append(byMessage[task.Message], &stored)
+			continue
+		}
+		result.IDs[task.ID] = duplicate.ID
+		if !merge {
+			result.Skipped++
+			continue
+		}
+		err = s.mergeTask(tx, duplicate, task)
+		if err != nil {
+			return nil, err
+		}
+		result.Merged++
+	}
+	for _, task := range children {
+		parentID, ok := result.IDs[task.ParentID]
+		if !ok {
+			// the parent was not part of the import
+			continue
+		}
+		err = s.SetTaskParent(tx, result.IDs[task.ID], parentID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func findDuplicate(candidates []*Task, task *Task) *Task {
+	for _, candidate := range candidates {
+		if len(candidate.Pomodoros) == 0 && len(task.Pomodoros) == 0 {
+			return candidate
+		}
+		starts := map[int64]bool{}
+		for _, pomodoro := range candidate.Pomodoros {
+			starts[pomodoroKey(pomodoro)] = true
+		}
+		for _, pomodoro := range task.Pomodoros {
+			if starts[pomodoroKey(pomodoro)] {
+				return candidate
+			}
+		}
+	}
+	return nil
+}
+
+// mergeTask adds the tags, pomodoros and breaks of
+// an imported task that the stored one is missing.
+func (s Store) mergeTask(tx *sql.Tx, stored, imported *Task) error {
+	tags := map[string]bool{}
+	for _, tag := range stored.Tags {
+		tags[tag] = true
+	}
+	var missingTags []string
+	for _, tag := range imported.Tags {
+		if !tags[tag] {
+			missingTags = append(missingTags, tag)
+			tags[tag] = true
+		}
+	}
+	err := setTaskTags(tx, stored.ID, missingTags)
+	if err != nil {
+		return err
+	}
+	stored.Tags = append(stored.Tags, missingTags...)
+	starts := map[int64]bool{}
+	for _, pomodoro := range stored.Pomodoros {
+		starts[pomodoroKey(pomodoro)] = true
+	}
+	for _, pomodoro := range imported.Pomodoros {
+		if starts[pomodoroKey(pomodoro)] {
+			continue
+		}
+		_, err = s.CreatePomodoro(tx, stored.ID, *pomodoro)
+		if err != nil {
+			return err
+		}
+		starts[pomodoroKey(pomodoro)] = true
+		stored.Pomodoros = append(stored.Pomodoros, pomodoro)
+	}
+	breaks := map[int64]bool{}
+	for _, b := range stored.Breaks {
+		breaks[b.Start.Unix()] = true
+	}
+	for _, b := range imported.Breaks {
+		if breaks[b.Start.Unix()] {
+			continue
+		}
+		err = s.CreateBreak(tx, stored.ID, *b)
+		if err != nil {
+			return err
+		}
+		breaks[b.Start.Unix()] = true
+		stored.Breaks = append(stored.Breaks, b)
+	}
+	return nil
+}
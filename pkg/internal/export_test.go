@@ -0,0 +1,175 @@
+package pomo
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	start := time.Date(2022, 1, 3, 9, 0, 0, 0, time.UTC)
+	tasks := []*Task{
+		{
+			ID:         7,
+			Message:    "write, the; code",
+			Tags:       []string{"work", "a,b"},
+			Project:    "pomo; cli",
+			ParentID:   9,
+			NPomodoros: 2,
+			Duration:   25 * time.Minute,
+			Pomodoros: []*Pomodoro{
+				{
+					Start:  start,
+					End:    start.Add(30 * time.Minute),
+					Status: PomodoroCompleted,
+					Pauses: []*Pause{{Start: start.Add(time.Minute), End: start.Add(6 * time.Minute)}},
+				},
+			},
+		},
+		{ID: 9, Message: "plan", NPomodoros: 1, Duration: 25 * time.Minute},
+	}
+	for _, format := range []ExportFormat{FormatJSON, FormatCSV, FormatICal} {
+		buf := bytes.NewBuffer(nil)
+		if err := ExportTasks(buf, format, tasks); err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseTasks(buf, format)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		expected := 2
+		if format == FormatICal {
+			// only pomodoros are exported as events
+			expected = 1
+		}
+		if len(parsed) != expected {
+			t.Fatalf("%s: expected %d tasks, got %d", format, expected, len(parsed))
+		}
+		task := parsed[0]
+		if task.ID != 7 || task.Message != tasks[0].Message || task.NPomodoros != 2 || task.Duration != 25*time.Minute {
+			t.Fatalf("%s: unexpected task %+v", format, task)
+		}
+		if task.Project != "pomo; cli" || task.ParentID != 9 {
+			t.Fatalf("%s: unexpected project %q and parent %d", format, task.Project, task.ParentID)
+		}
+		if len(task.Tags) != 2 || task.Tags[1] != "a,b" {
+			t.Fatalf("%s: unexpected tags %v", format, task.Tags)
+		}
+		if len(task.Pomodoros) != 1 || !task.Pomodoros[0].Start.Equal(start) {
+			t.Fatalf("%s: unexpected pomodoros %v", format, task.Pomodoros)
+		}
+		if pomodoro := task.Pomodoros[0]; pomodoro.Worked() != 25*time.Minute || pomodoro.Status != PomodoroCompleted {
+			t.Fatalf("%s: expected 25m completed, got %s %s", format, pomodoro.Worked(), pomodoro.Status)
+		}
+	}
+}
+
+func TestImportTasks(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	start := time.Date(2022, 1, 3, 9, 0, 0, 0, time.UTC)
+	pomodoro := func(offset time.Duration) *Pomodoro {
+		return &Pomodoro{Start: start.Add(offset), End: start.Add(offset + 25*time.Minute)}
+	}
+	task := func(id int, message string, pomodoros ...*Pomodoro) *Task {
+		return &Task{ID: id, Message: message, NPomodoros: 2, Duration: 25 * time.Minute, Pomodoros: pomodoros}
+	}
+	laptop := []*Task{task(1, "code", pomodoro(0)), task(2, "plan")}
+	laptop[0].Tags = []string{"work"}
+	desktop := []*Task{
+		task(1, "code", pomodoro(0), pomodoro(time.Hour)),
+		// same message but a different sub-task
+		task(2, "code", pomodoro(24*time.Hour)),
+	}
+	desktop[0].Tags = []string{"go"}
+	desktop[1].ParentID = 1
+	var results []*ImportResult
+	err := store.With(func(tx *sql.Tx) error {
+		for _, imported := range [][]*Task{laptop, desktop, desktop} {
+			result, err := store.ImportTasks(tx, imported, true)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+		}
+		result, err := store.ImportTasks(tx, laptop, false)
+		results = append(results, result)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Created != 2 || results[1].Created != 1 || results[1].Merged != 1 {
+		t.Fatalf("unexpected results %+v %+v", results[0], results[1])
+	}
+	if results[2].Merged != 2 || results[3].Skipped != 2 {
+		t.Fatalf("expected repeated imports to change nothing, got %+v %+v", results[2], results[3])
+	}
+	if results[1].IDs[1] != 1 || results[1].IDs[2] != 3 {
+		t.Fatalf("unexpected id mapping %v", results[1].IDs)
+	}
+	stored := readTestTask(t, store, 1)
+	if len(stored.Pomodoros) != 2 || len(stored.Tags) != 2 || stored.Tags[1] != "go" {
+		t.Fatalf("unexpected merged task %+v", stored)
+	}
+	if stored = readTestTask(t, store, 3); stored.ParentID != 1 {
+		t.Fatalf("expected the parent of the imported task to be 1, got %d", stored.ParentID)
+	}
+
+	// invalid tasks reject the whole import
+	bogus := task(4, "bogus")
+	bogus.State = "bogus"
+	for _, invalid := range []*Task{bogus, task(5, "")} {
+		err = store.With(func(tx *sql.Tx) error {
+			_, err := store.ImportTasks(tx, []*Task{task(6, "valid"), invalid}, false)
+			return err
+		})
+		if err == nil {
+			t.Fatalf("expected importing %+v to fail", invalid)
+		}
+	}
+	err = store.With(func(tx *sql.Tx) error {
+		tasks, err := store.ReadTasks(tx)
+		if len(tasks) != 3 {
+			t.Fatalf("expected nothing to be imported, got %d tasks", len(tasks))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestICalFolding(t *testing.T) {
+	start := time.Date(2022, 1, 3, 9, 0, 0, 0, time.UTC)
+	message := strings.Repeat("write the code, ", 10) + strings.Repeat("ünïcödé ", 20)
+	tasks := []*Task{{
+		ID:         1,
+		Message:    message,
+		NPomodoros: 1,
+		Duration:   25 * time.Minute,
+		Pomodoros:  []*Pomodoro{{Start: start, End: start.Add(25 * time.Minute)}},
+	}}
+	buf := bytes.NewBuffer(nil)
+	if err := ExportTasks(buf, FormatICal, tasks); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.String()
+	if !strings.Contains(raw, "\r\n ") {
+		t.Fatal("expected the summary to be folded")
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(raw, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Fatalf("line of %d octets is longer than 75: %q", len(line), line)
+		}
+	}
+	parsed, err := ParseTasks(buf, FormatICal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 1 || parsed[0].Message != message {
+		t.Fatalf("expected the folded summary to be read back, got %v", parsed)
+	}
+}
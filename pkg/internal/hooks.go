@@ -0,0 +1,218 @@
+package pomo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultHookTimeout = 30 * time.Second
+
+// hookEvents are the event types hooks may run for
+var hookEvents = []EventType{
+	EventPomodoroStarted,
+	EventPomodoroEnded,
+	EventPaused,
+	EventResumed,
+	EventBreakStarted,
+	EventBreakEnded,
+	EventSessionComplete,
+}
+
+// Hook runs a command when the session emits one of its
+// events, the command is given the event and the task as
+// JSON on stdin and as POMO_ variables in its environment.
+type Hook struct {
+	// On lists the events the hook runs
+	// for, it runs for all of them if empty
+	On      []EventType
+	Command []string
+	// Timeout is how long the command may run
+	Timeout time.Duration
+	// Block holds the session until the command
+	// exits instead of running it in the background
+	Block bool
+}
+
+type hookJSON struct {
+	On      []EventType `json:"on"`
+	Command []string    `json:"command"`
+	Timeout string      `json:"timeout"`
+	Block   bool        `json:"block"`
+}
+
+func (h Hook) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hookJSON{
+		On:      h.On,
+		Command: h.Command,
+		Timeout: h.Timeout.String(),
+		Block:   h.Block,
+	})
+}
+
+func (h *Hook) UnmarshalJSON(raw []byte) error {
+	parsed := hookJSON{}
+	err := json.Unmarshal(raw, &parsed)
+	if err != nil {
+		return err
+	}
+	if len(parsed.Command) == 0 {
+		return fmt.Errorf("hook is missing a command")
+	}
+	err = validateEvents(parsed.On)
+	if err != nil {
+		return err
+	}
+	hook := Hook{
+		On:      parsed.On,
+		Command: parsed.Command,
+		Timeout: defaultHookTimeout,
+		Block:   parsed.Block,
+	}
+	if parsed.Timeout != "" {
+		hook.Timeout, err = time.ParseDuration(parsed.Timeout)
+		if err != nil {
+			return err
+		}
+	}
+	*h = hook
+	return nil
+}
+
+// legacyHook runs the onEvent command each time
+// the state of the session changes as it used to.
+func legacyHook(command []string) Hook {
+	return Hook{
+		On: []EventType{
+			EventPomodoroStarted,
+			EventPaused,
+			EventResumed,
+			EventBreakStarted,
+			EventSessionComplete,
+		},
+		Command: command,
+		Timeout: defaultHookTimeout,
+	}
+}
+
+// Matches reports whether the hook runs for the event type
+func (h Hook) Matches(eventType EventType) bool {
+	return matchesEvent(h.On, eventType)
+}
+
+// validateEvents checks that hooks can run on each event
+func validateEvents(on []EventType) error {
+	for _, eventType := range on {
+		if !matchesEvent(nil, eventType) {
+			return fmt.Errorf("hooks cannot run on %q", eventType)
+		}
+	}
+	return nil
+}
+
+// matchesEvent reports whether the event type is one of on
+// or is one of the hook events if on is empty.
+func matchesEvent(on []EventType, eventType EventType) bool {
+	if len(on) == 0 {
+		on = hookEvents
+	}
+	for _, hookEvent := range on {
+		if eventType == hookEvent {
+			return true
+		}
+	}
+	return false
+}
+
+// HookPayload is written to the stdin of hooks
+type HookPayload struct {
+	Event Event `json:"event"`
+	Task  *Task `json:"task"`
+}
+
+// Env returns the payload as environment variables
+func (p HookPayload) Env() []string {
+	status := p.Event.Status
+	env := []string{
+		fmt.Sprintf("POMO_EVENT=%s", p.Event.Type),
+		fmt.Sprintf("POMO_STATE=%s", status.State),
+		fmt.Sprintf("POMO_TASK_ID=%d", status.TaskID),
+		fmt.Sprintf("POMO_TASK_MESSAGE=%s", status.TaskMessage),
+		fmt.Sprintf("POMO_COUNT=%d", status.Count),
+		fmt.Sprintf("POMO_N_POMODOROS=%d", status.NPomodoros),
+		fmt.Sprintf("POMO_ESTIMATE=%d", status.Estimate),
+		fmt.Sprintf("POMO_REMAINING=%d", int(status.Remaining.Seconds())),
+	}
+	if p.Task != nil {
+		env = append(env,
+			fmt.Sprintf("POMO_TASK_TAGS=%s", strings.Join(p.Task.Tags, ",")),
+			fmt.Sprintf("POMO_TASK_PROJECT=%s", p.Task.Project),
+			fmt.Sprintf("POMO_TASK_PARENT_ID=%d", p.Task.ParentID),
+			fmt.Sprintf("POMO_TASK_DURATION=%d", int(p.Task.Duration.Seconds())),
+		)
+	}
+	return env
+}
+
+// Run runs the hook command with the payload
+// and returns anything it wrote to its output.
+func (h Hook) Run(payload HookPayload) ([]byte, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return runCommand(h.Command, h.Timeout, payload.Env(), bytes.NewReader(raw))
+}
+
+// runCommand runs the command until it exits or the timeout
+// passes, the output of a failed command is part of the error.
+func runCommand(command []string, timeout time.Duration, env []string, stdin io.Reader) ([]byte, error) {
+	output := bytes.NewBuffer(nil)
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = stdin
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.SysProcAttr = commandProcAttr()
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	timedOut := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() {
+		close(timedOut)
+		killCommand(cmd.Process)
+	})
+	err = cmd.Wait()
+	timer.Stop()
+	select {
+	case <-timedOut:
+		return output.Bytes(), fmt.Errorf("timed out after %s", timeout)
+	default:
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != "" {
+			return output.Bytes(), fmt.Errorf("%s: %s", err, msg)
+		}
+		return output.Bytes(), err
+	}
+	return output.Bytes(), nil
+}
+
+// quoteCommand formats a command for the log
+func quoteCommand(command []string) string {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = arg
+		if strings.ContainsAny(arg, " \t\"'") {
+			quoted[i] = strconv.Quote(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,117 @@
+package pomo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHookConfig(t *testing.T) {
+	config := &Config{}
+	err := json.Unmarshal([]byte(`{
+		"onEvent": ["/bin/true"],
+		"hooks": [
+			{"on": ["pomodoro_started", "break_ended"], "command": ["/bin/true"], "timeout": "5s", "block": true},
+			{"command": ["/bin/true"]}
+		]
+	}`), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks := config.hooks()
+	if len(hooks) != 3 {
+		t.Fatalf("expected 3 hooks, got %d", len(hooks))
+	}
+	if !hooks[0].Block || hooks[0].Timeout != 5*time.Second || hooks[1].Timeout != defaultHookTimeout {
+		t.Fatalf("unexpected hooks %+v", hooks)
+	}
+	if !hooks[0].Matches(EventBreakEnded) || hooks[0].Matches(EventPaused) {
+		t.Fatalf("unexpected events for %+v", hooks[0])
+	}
+	if !hooks[1].Matches(EventSessionComplete) || hooks[1].Matches(EventTick) {
+		t.Fatal("hooks without events should run for every event but ticks")
+	}
+	if hooks[2].Matches(EventPomodoroEnded) || !hooks[2].Matches(EventBreakStarted) {
+		t.Fatal("onEvent should only run when the state changes")
+	}
+
+	for _, raw := range []string{
+		`{"hooks": [{"on": ["pomodoro_started"]}]}`,
+		`{"hooks": [{"on": ["tick"], "command": ["/bin/true"]}]}`,
+		`{"hooks": [{"command": ["/bin/true"], "timeout": "soon"}]}`,
+	} {
+		if err := json.Unmarshal([]byte(raw), &Config{}); err == nil {
+			t.Fatalf("expected an error for %s", raw)
+		}
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	start := time.Now()
+	hook := Hook{Command: []string{"sh", "-c", "sleep 5 & sleep 5"}, Timeout: 100 * time.Millisecond}
+	_, err := hook.Run(HookPayload{})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected the hook to time out, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("hook ran past its timeout")
+	}
+}
+
+func TestTaskRunnerHooks(t *testing.T) {
+	runner, store, clock := initTestRunner(t, &Task{
+		Duration:   25 * time.Minute,
+		NPomodoros: 1,
+		Message:    "Test Task",
+		Tags:       []string{"a", "b"},
+	})
+	defer store.Close()
+	baseDir, _ := ioutil.TempDir("/tmp", "")
+	defer os.RemoveAll(baseDir)
+	output := path.Join(baseDir, "output")
+	runner.hooks = []Hook{{
+		On: []EventType{EventPomodoroStarted, EventPaused, EventSessionComplete},
+		Command: []string{"sh", "-c",
+			`echo "$POMO_EVENT $POMO_STATE $POMO_TASK_TAGS $(cat)" >> ` + output},
+		Timeout: defaultHookTimeout,
+		Block:   true,
+	}}
+
+	runner.Start()
+	waitState(t, runner, RUNNING)
+	// commands are handled once the blocking hooks have run
+	if err := runner.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(25 * time.Minute)
+	<-runner.Done()
+
+	raw, _ := ioutil.ReadFile(output)
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 hook runs, got %q", raw)
+	}
+	for i, prefix := range []string{
+		"pomodoro_started RUNNING a,b ",
+		"paused PAUSED a,b ",
+		"session_complete COMPLETE a,b ",
+	} {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Fatalf("expected %q to start with %q", lines[i], prefix)
+		}
+		payload := HookPayload{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[i], prefix)), &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Task == nil || payload.Task.Message != "Test Task" || payload.Event.Status.TaskID != runner.taskID {
+			t.Fatalf("unexpected payload %s", lines[i])
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package pomo
+
+import (
+	"os"
+	"syscall"
+)
+
+// commandProcAttr starts a command in a new process group
+// so any processes it starts can be stopped along with it.
+func commandProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killCommand kills the process group of a command
+func killCommand(process *os.Process) error {
+	return syscall.Kill(-process.Pid, syscall.SIGKILL)
+}
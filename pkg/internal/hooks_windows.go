@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package pomo
+
+import (
+	"os"
+	"syscall"
+)
+
+func commandProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{}
+}
+
+func killCommand(process *os.Process) error {
+	return process.Kill()
+}
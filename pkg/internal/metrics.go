@@ -0,0 +1,152 @@
+package pomo
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetricsContentType is the Prometheus text exposition format
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricsInterval is how often the metrics
+// textfile is rewritten while a session runs
+const metricsInterval = 15 * time.Second
+
+// metricStates are exported by pomo_state in this order
+var metricStates = []State{CREATED, RUNNING, BREAKING, PAUSED, COMPLETE}
+
+// Metrics are exported for Prometheus, the gauges describe the
+// running session and the counters are totals of the pomodoros
+// recorded in the store so they fall if tasks are deleted.
+type Metrics struct {
+	// Status is nil when no session is running
+	Status *Status
+	// Completed is the number of completed pomodoros of
+	// each tag, tasks without tags are counted under "-".
+	Completed map[string]int
+	Focused   time.Duration
+	Pauses    int
+}
+
+// ReadMetrics totals the pomodoros in the store with aggregate
+// queries so reading them does not grow with the history.
+func (s Store) ReadMetrics(tx *sql.Tx) (*Metrics, error) {
+	metrics := &Metrics{Completed: map[string]int{}}
+	rows, err := tx.Query(`
+	SELECT COALESCE(tag.name, '-'), COUNT(*) FROM pomodoro
+	LEFT JOIN task_tag ON task_tag.task_id = pomodoro.task_id
+	LEFT JOIN tag ON tag.rowid = task_tag.tag_id
+	WHERE pomodoro.status = $1
+	GROUP BY 1`, PomodoroCompleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			tag string
+			n   int
+		)
+		err = rows.Scan(&tag, &n)
+		if err != nil {
+			return nil, err
+		}
+		metrics.Completed[tag] = n
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// durations are summed in milliseconds
+	var worked, paused int64
+	err = tx.QueryRow(`
+	SELECT
+	    (SELECT CAST(COALESCE(SUM(ROUND((julianday(end) - julianday(start)) * 86400000)), 0) AS INTEGER) FROM pomodoro),
+	    (SELECT CAST(COALESCE(SUM(ROUND((julianday(end) - julianday(start)) * 86400000)), 0) AS INTEGER) FROM pause),
+	    (SELECT COUNT(*) FROM pause)`).Scan(&worked, &paused, &metrics.Pauses)
+	if err != nil {
+		return nil, err
+	}
+	metrics.Focused = time.Duration(worked-paused) * time.Millisecond
+	return metrics, nil
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteText writes the metrics in the Prometheus text format
+func (m Metrics) WriteText(w io.Writer) error {
+	buf := bytes.NewBuffer(nil)
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	value := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	metric("pomo_state", "gauge", "State of the running session, 1 for the current state.")
+	for _, state := range metricStates {
+		current := 0
+		if m.Status != nil && m.Status.State == state {
+			current = 1
+		}
+		fmt.Fprintf(buf, "pomo_state{state=\"%s\"} %d\n", strings.ToLower(state.String()), current)
+	}
+	var remaining time.Duration
+	if m.Status != nil {
+		remaining = m.Status.Remaining
+	}
+	metric("pomo_remaining_seconds", "gauge", "Time remaining in the current pomodoro or break.")
+	fmt.Fprintf(buf, "pomo_remaining_seconds %s\n", value(remaining.Seconds()))
+
+	metric("pomo_pomodoros_completed_total", "counter", "Pomodoros completed of tasks with each tag.")
+	tags := make([]string, 0, len(m.Completed))
+	for tag := range m.Completed {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		fmt.Fprintf(buf, "pomo_pomodoros_completed_total{tag=\"%s\"} %d\n", labelEscaper.Replace(tag), m.Completed[tag])
+	}
+	metric("pomo_focused_seconds_total", "counter", "Time spent in pomodoros excluding pauses.")
+	fmt.Fprintf(buf, "pomo_focused_seconds_total %s\n", value(m.Focused.Seconds()))
+	metric("pomo_pauses_total", "counter", "Pauses taken during pomodoros.")
+	fmt.Fprintf(buf, "pomo_pauses_total %d\n", m.Pauses)
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// WriteFile replaces the file at filePath with the metrics, the
+// file is renamed into place so the textfile collector of the
+// node exporter never reads it partially written.
+func (m Metrics) WriteFile(filePath string) error {
+	buf := bytes.NewBuffer(nil)
+	err := m.WriteText(buf)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(path.Dir(filePath), "."+path.Base(filePath))
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
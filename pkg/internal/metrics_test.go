@@ -0,0 +1,157 @@
+package pomo
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	start := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	tasks := []*Task{
+		{Message: "one", Tags: []string{"code", `say "hi"`}, Pomodoros: []*Pomodoro{
+			{Start: start, End: start.Add(25 * time.Minute), Status: PomodoroCompleted, Pauses: []*Pause{
+				{Start: start.Add(time.Minute), End: start.Add(2 * time.Minute)},
+				{Start: start.Add(3 * time.Minute), End: start.Add(4 * time.Minute)},
+			}},
+			{Start: start, End: start.Add(10 * time.Minute), Status: PomodoroInterrupted},
+		}},
+		{Message: "two", Pomodoros: []*Pomodoro{
+			{Start: start, End: start.Add(25 * time.Minute), Status: PomodoroCompleted},
+		}},
+	}
+	var metrics *Metrics
+	err := store.With(func(tx *sql.Tx) error {
+		for _, task := range tasks {
+			taskID, err := store.CreateTask(tx, *task)
+			if err != nil {
+				return err
+			}
+			for _, pomodoro := range task.Pomodoros {
+				if _, err := store.CreatePomodoro(tx, taskID, *pomodoro); err != nil {
+					return err
+				}
+			}
+		}
+		var err error
+		metrics, err = store.ReadMetrics(tx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics.Status = &Status{State: PAUSED, Remaining: 90 * time.Second}
+	buf := bytes.NewBuffer(nil)
+	if err := metrics.WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP pomo_state State of the running session, 1 for the current state.
+# TYPE pomo_state gauge
+pomo_state{state="created"} 0
+pomo_state{state="running"} 0
+pomo_state{state="breaking"} 0
+pomo_state{state="paused"} 1
+pomo_state{state="complete"} 0
+# HELP pomo_remaining_seconds Time remaining in the current pomodoro or break.
+# TYPE pomo_remaining_seconds gauge
+pomo_remaining_seconds 90
+# HELP pomo_pomodoros_completed_total Pomodoros completed of tasks with each tag.
+# TYPE pomo_pomodoros_completed_total counter
+pomo_pomodoros_completed_total{tag="-"} 1
+pomo_pomodoros_completed_total{tag="code"} 1
+pomo_pomodoros_completed_total{tag="say \"hi\""} 1
+# HELP pomo_focused_seconds_total Time spent in pomodoros excluding pauses.
+# TYPE pomo_focused_seconds_total counter
+pomo_focused_seconds_total 3480
+# HELP pomo_pauses_total Pauses taken during pomodoros.
+# TYPE pomo_pauses_total counter
+pomo_pauses_total 2
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected metrics:\n%s", buf.String())
+	}
+
+	// without a session every state is 0
+	metrics.Status = nil
+	buf.Reset()
+	metrics.WriteText(buf)
+	if strings.Contains(buf.String(), "} 1\npomo_state") || !strings.Contains(buf.String(), "pomo_remaining_seconds 0\n") {
+		t.Fatalf("unexpected metrics without a session:\n%s", buf.String())
+	}
+}
+
+func TestTaskRunnerMetrics(t *testing.T) {
+	runner, store, clock := initTestRunner(t, &Task{
+		Duration:   25 * time.Minute,
+		NPomodoros: 1,
+		Message:    "Test Task",
+		Tags:       []string{"code"},
+	})
+	defer store.Close()
+	dir, _ := ioutil.TempDir("/tmp", "")
+	defer os.RemoveAll(dir)
+	runner.metricsPath = path.Join(dir, "pomo.prom")
+	readMetrics := func() string {
+		t.Helper()
+		raw, err := ioutil.ReadFile(runner.metricsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(raw)
+	}
+
+	waitMetrics := func(line string) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			// the file is written in the background
+			if raw, _ := ioutil.ReadFile(runner.metricsPath); strings.Contains(string(raw), line) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected %q in metrics:\n%s", line, readMetrics())
+	}
+
+	runner.Start()
+	waitState(t, runner, RUNNING)
+	if err := runner.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	// events do not write the metrics, only the interval does
+	if _, err := os.Stat(runner.metricsPath); !os.IsNotExist(err) {
+		t.Fatalf("expected no metrics before the interval, got %v", err)
+	}
+	clock.Advance(metricsInterval)
+	waitMetrics(`pomo_state{state="paused"} 1`)
+	if metrics := readMetrics(); !strings.Contains(metrics, "pomo_remaining_seconds 1500\n") {
+		t.Fatalf("unexpected metrics while paused:\n%s", metrics)
+	}
+	if err := runner.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(25 * time.Minute)
+	<-runner.Done()
+
+	metrics := readMetrics()
+	for _, line := range []string{
+		`pomo_state{state="complete"} 0`,
+		"pomo_remaining_seconds 0\n",
+		`pomo_pomodoros_completed_total{tag="code"} 1`,
+		"pomo_focused_seconds_total 1500\n",
+		"pomo_pauses_total 1\n",
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Fatalf("expected %q in metrics:\n%s", line, metrics)
+		}
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("expected only the metrics file, got %d files", len(files))
+	}
+}
@@ -0,0 +1,383 @@
+package pomo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrSchemaOutdated is returned when opening a
+// database that was created by an older version
+// of pomo and has not yet been migrated.
+var ErrSchemaOutdated = errors.New("database schema is outdated, run `pomo migrate` to upgrade it")
+
+// migration upgrades the database schema
+// by a single version.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// execStmt returns a migration func that
+// executes a static SQL statement.
+func execStmt(stmt string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(stmt)
+		return err
+	}
+}
+
+// migrations are applied in order, new migrations
+// must always be appended with the next version.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create task and pomodoro tables",
+		up: execStmt(`
+    CREATE TABLE task (
+	message TEXT,
+	pomodoros INTEGER,
+	duration TEXT,
+	tags TEXT
+    );
+    CREATE TABLE pomodoro (
+	task_id INTEGER,
+	start DATETTIME,
+	end DATETTIME
+    );
+    `),
+	},
+	{
+		version:     2,
+		description: "move tags into tag and task_tag tables",
+		up:          migrateTags,
+	},
+	{
+		version:     3,
+		description: "create break table",
+		up: execStmt(`
+    CREATE TABLE break (
+	task_id INTEGER,
+	start DATETIME,
+	end DATETIME,
+	planned TEXT
+    );
+    `),
+	},
+	{
+		version:     4,
+		description: "add status to pomodoro",
+		up: execStmt(`
+    ALTER TABLE pomodoro ADD COLUMN status TEXT NOT NULL DEFAULT 'completed';
+    `),
+	},
+	{
+		version:     5,
+		description: "create pause table",
+		up: execStmt(`
+    CREATE TABLE pause (
+	pomodoro_id INTEGER NOT NULL,
+	start DATETIME,
+	end DATETIME
+    );
+    `),
+	},
+	{
+		version:     6,
+		description: "create checkpoint table",
+		up: execStmt(`
+    CREATE TABLE checkpoint (
+	task_id INTEGER NOT NULL,
+	state INTEGER NOT NULL,
+	count INTEGER NOT NULL,
+	started DATETIME,
+	duration TEXT,
+	remaining TEXT,
+	pauses TEXT,
+	updated DATETIME
+    );
+    `),
+	},
+	{
+		version:     7,
+		description: "give each pomodoro a stable id",
+		// The rowid of a table without an INTEGER PRIMARY KEY
+		// may change on VACUUM so existing rowids are copied into
+		// an explicit id column. The start and end columns keep
+		// their original type so they are still read as text.
+		up: execStmt(`
+    CREATE TABLE pomodoro_with_id (
+	id INTEGER PRIMARY KEY,
+	task_id INTEGER,
+	start DATETTIME,
+	end DATETTIME,
+	status TEXT NOT NULL DEFAULT 'completed'
+    );
+    INSERT INTO pomodoro_with_id (id, task_id, start, end, status)
+	SELECT rowid, task_id, start, end, status FROM pomodoro;
+    DROP TABLE pomodoro;
+    ALTER TABLE pomodoro_with_id RENAME TO pomodoro;
+    `),
+	},
+	{
+		version:     8,
+		description: "add state to task",
+		// existing tasks are done once all of their planned
+		// pomodoros were completed and active if started
+		up: execStmt(`
+    ALTER TABLE task ADD COLUMN state TEXT NOT NULL DEFAULT 'todo';
+    UPDATE task SET state = 'active'
+	WHERE EXISTS (SELECT 1 FROM pomodoro WHERE pomodoro.task_id = task.rowid);
+    UPDATE task SET state = 'done'
+	WHERE state = 'active' AND pomodoros <= (
+	    SELECT COUNT(*) FROM pomodoro
+	    WHERE pomodoro.task_id = task.rowid AND pomodoro.status = 'completed');
+    `),
+	},
+	{
+		version:     9,
+		description: "create project table and add project and parent to task",
+		up: execStmt(`
+    CREATE TABLE project (
+	name TEXT NOT NULL UNIQUE
+    );
+    ALTER TABLE task ADD COLUMN project_id INTEGER;
+    ALTER TABLE task ADD COLUMN parent_id INTEGER;
+    `),
+	},
+	{
+		version:     10,
+		description: "add finished to task and target to checkpoint",
+		up: execStmt(`
+    ALTER TABLE task ADD COLUMN finished DATETIME;
+    UPDATE task SET finished = (
+	SELECT MAX(pomodoro.end) FROM pomodoro WHERE pomodoro.task_id = task.rowid)
+    WHERE state = 'done';
+    ALTER TABLE checkpoint ADD COLUMN target INTEGER NOT NULL DEFAULT 0;
+    `),
+	},
+	{
+		version:     11,
+		description: "add pid to checkpoint",
+		up: execStmt(`
+    ALTER TABLE checkpoint ADD COLUMN pid INTEGER NOT NULL DEFAULT 0;
+    `),
+	},
+	{
+		version:     12,
+		description: "give each task a stable id",
+		// Pomodoros, breaks, tags and sub-tasks refer to the
+		// rowid of a task so it is copied into an explicit id
+		// column the same way as for pomodoros in version 7.
+		up: execStmt(`
+    CREATE TABLE task_with_id (
+	id INTEGER PRIMARY KEY,
+	message TEXT,
+	pomodoros INTEGER,
+	duration TEXT,
+	state TEXT NOT NULL DEFAULT 'todo',
+	project_id INTEGER,
+	parent_id INTEGER,
+	finished DATETIME
+    );
+    INSERT INTO task_with_id (id, message, pomodoros, duration, state, project_id, parent_id, finished)
+	SELECT rowid, message, pomodoros, duration, state, project_id, parent_id, finished FROM task;
+    DROP TABLE task;
+    ALTER TABLE task_with_id RENAME TO task;
+    `),
+	},
+}
+
+// SchemaVersion is the database schema
+// version expected by this build of pomo.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrateTags moves the comma joined task.tags column into
+// the normalized tag tables. The statements are written out
+// rather than shared with the store so the migration does
+// not change along with it.
+func migrateTags(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+    CREATE TABLE tag (
+	name TEXT NOT NULL UNIQUE
+    );
+    CREATE TABLE task_tag (
+	task_id INTEGER NOT NULL,
+	tag_id INTEGER NOT NULL,
+	UNIQUE(task_id, tag_id)
+    );
+    `)
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query("SELECT rowid,tags FROM task WHERE tags != ''")
+	if err != nil {
+		return err
+	}
+	joined := map[int]string{}
+	for rows.Next() {
+		var (
+			taskID int
+			tags   string
+		)
+		err = rows.Scan(&taskID, &tags)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		joined[taskID] = tags
+	}
+	rows.Close()
+	for taskID, tags := range joined {
+		for _, tag := range strings.Split(tags, ",") {
+			if tag == "" {
+				continue
+			}
+			_, err = tx.Exec("INSERT OR IGNORE INTO tag (name) VALUES ($1)", tag)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(
+				"INSERT OR IGNORE INTO task_tag (task_id,tag_id) SELECT $1,rowid FROM tag WHERE name = $2",
+				taskID, tag)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// The task table is rebuilt without the tags column as
+	// DROP COLUMN needs SQLite 3.35, rowids are copied since
+	// pomodoros and tags refer to them.
+	_, err = tx.Exec(`
+    CREATE TABLE task_without_tags (
+	message TEXT,
+	pomodoros INTEGER,
+	duration TEXT
+    );
+    INSERT INTO task_without_tags (rowid, message, pomodoros, duration)
+	SELECT rowid, message, pomodoros, duration FROM task;
+    DROP TABLE task;
+    ALTER TABLE task_without_tags RENAME TO task;
+    `)
+	return err
+}
+
+func tableExists(tx *sql.Tx, name string) (bool, error) {
+	var n int
+	err := tx.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1", name).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Version returns the current schema version of
+// the database, zero indicates that it has not
+// been initialized.
+func (s Store) Version(tx *sql.Tx) (int, error) {
+	exists, err := tableExists(tx, "schema_version")
+	if err != nil {
+		return -1, err
+	}
+	if !exists {
+		// Databases created before versioning was
+		// introduced only contain the original tables.
+		legacy, err := tableExists(tx, "task")
+		if err != nil {
+			return -1, err
+		}
+		if legacy {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	var version sql.NullInt64
+	err = tx.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		return -1, err
+	}
+	return int(version.Int64), nil
+}
+
+// checkVersion ensures the database schema
+// is compatible with this build of pomo.
+func (s Store) checkVersion() error {
+	return s.With(func(tx *sql.Tx) error {
+		version, err := s.Version(tx)
+		if err != nil {
+			return err
+		}
+		switch {
+		case version == 0:
+			// uninitialized
+			return nil
+		case version < SchemaVersion():
+			return ErrSchemaOutdated
+		case version > SchemaVersion():
+			return fmt.Errorf(
+				"database schema version %d is newer than supported version %d, upgrade pomo",
+				version, SchemaVersion())
+		}
+		return nil
+	})
+}
+
+// MigrateDB applies all pending migrations, each
+// within its own transaction, returning the schema
+// versions before and after the upgrade.
+func MigrateDB(db *Store) (int, int, error) {
+	var from int
+	err := db.With(func(tx *sql.Tx) error {
+		version, err := db.Version(tx)
+		if err != nil {
+			return err
+		}
+		from = version
+		_, err = tx.Exec(`
+    CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	description TEXT,
+	applied DATETIME
+    );
+    `)
+		if err != nil {
+			return err
+		}
+		if version == 1 {
+			// record the legacy schema so it
+			// is not created a second time
+			return recordMigration(tx, migrations[0])
+		}
+		return nil
+	})
+	if err != nil {
+		return -1, -1, err
+	}
+	current := from
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		err := db.With(m.up, func(tx *sql.Tx) error {
+			return recordMigration(tx, m)
+		})
+		if err != nil {
+			return from, current, fmt.Errorf("migration %d (%s): %s", m.version, m.description, err)
+		}
+		current = m.version
+	}
+	return from, current, nil
+}
+
+func recordMigration(tx *sql.Tx, m migration) error {
+	_, err := tx.Exec(
+		"INSERT OR IGNORE INTO schema_version (version,description,applied) VALUES ($1,$2,$3)",
+		m.version, m.description, time.Now())
+	return err
+}
@@ -0,0 +1,496 @@
+package pomo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xAX/notificator"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	defaultBellDevice     = "/dev/tty"
+	defaultCommandTimeout = 10 * time.Second
+
+	dbusNotificationsName      = "org.freedesktop.Notifications"
+	dbusNotificationsPath      = dbus.ObjectPath("/org/freedesktop/Notifications")
+	dbusNotificationsInterface = "org.freedesktop.Notifications"
+)
+
+// Urgency is how important a notification is
+type Urgency string
+
+const (
+	UrgencyLow      Urgency = "low"
+	UrgencyNormal   Urgency = "normal"
+	UrgencyCritical Urgency = "critical"
+)
+
+func (u Urgency) valid() bool {
+	switch u {
+	case UrgencyLow, UrgencyNormal, UrgencyCritical:
+		return true
+	}
+	return false
+}
+
+// level is the urgency as defined by the
+// desktop notifications specification.
+func (u Urgency) level() byte {
+	switch u {
+	case UrgencyLow:
+		return 0
+	case UrgencyCritical:
+		return 2
+	}
+	return 1
+}
+
+// NotificationAction is a button offered
+// to the user along with a notification.
+type NotificationAction struct {
+	Key   string
+	Label string
+}
+
+// Notification is a message about the session
+type Notification struct {
+	Title   string
+	Body    string
+	Urgency Urgency
+	// Actions are offered by notifiers which support them,
+	// OnAction is called with the key of the chosen action.
+	Actions  []NotificationAction
+	OnAction func(key string)
+}
+
+// Notifier sends a notification to the user
+type Notifier interface {
+	Notify(Notification) error
+}
+
+// NoopNotifier does nothing
+type NoopNotifier struct{}
+
+func (n NoopNotifier) Notify(Notification) error { return nil }
+
+// NotifierFactory creates a notifier from its JSON configuration
+type NotifierFactory func(config *Config, raw json.RawMessage) (Notifier, error)
+
+var notifierFactories = map[string]NotifierFactory{}
+
+// RegisterNotifier makes a notifier available to the
+// configuration by name, it should be called from init.
+func RegisterNotifier(name string, factory NotifierFactory) {
+	notifierFactories[name] = factory
+}
+
+func init() {
+	RegisterNotifier("xnotifier", newXnotifier)
+	RegisterNotifier("dbus", newDBusNotifier)
+	RegisterNotifier("bell", newBellNotifier)
+	RegisterNotifier("command", newCommandNotifier)
+}
+
+// NotifierConfig selects a registered notifier by its type,
+// the remaining fields are options of that notifier.
+type NotifierConfig struct {
+	Type string
+	raw  json.RawMessage
+}
+
+func (n NotifierConfig) MarshalJSON() ([]byte, error) {
+	if n.raw != nil {
+		return n.raw, nil
+	}
+	return json.Marshal(map[string]string{"type": n.Type})
+}
+
+func (n *NotifierConfig) UnmarshalJSON(raw []byte) error {
+	parsed := struct {
+		Type string `json:"type"`
+	}{}
+	err := json.Unmarshal(raw, &parsed)
+	if err != nil {
+		return err
+	}
+	if parsed.Type == "" {
+		return fmt.Errorf("notifier is missing a type")
+	}
+	n.Type = parsed.Type
+	n.raw = append(json.RawMessage{}, raw...)
+	return nil
+}
+
+// NewNotifier creates all of the notifiers selected by
+// the config, the Xnotifier is used if none are.
+func NewNotifier(config *Config) (Notifier, error) {
+	configs := config.Notifiers
+	if len(configs) == 0 {
+		configs = []NotifierConfig{{Type: "xnotifier"}}
+	}
+	multi := MultiNotifier{}
+	for _, notifierConfig := range configs {
+		factory, ok := notifierFactories[notifierConfig.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown notifier %q", notifierConfig.Type)
+		}
+		raw := notifierConfig.raw
+		if raw == nil {
+			raw = json.RawMessage("{}")
+		}
+		notifier, err := factory(config, raw)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s: %s", notifierConfig.Type, err)
+		}
+		multi = append(multi, NamedNotifier{Name: notifierConfig.Type, Notifier: notifier})
+	}
+	return multi, nil
+}
+
+// NamedNotifier identifies a notifier in the log
+type NamedNotifier struct {
+	Name string
+	Notifier
+}
+
+// MultiNotifier sends each notification to all of its
+// notifiers at once, failures are logged rather than
+// returned so one notifier cannot hold up the others.
+type MultiNotifier []NamedNotifier
+
+func (m MultiNotifier) Notify(notification Notification) error {
+	var wg sync.WaitGroup
+	for i, notifier := range m {
+		wg.Add(1)
+		go func(i int, notifier NamedNotifier) {
+			defer wg.Done()
+			err := notifier.Notify(notification)
+			if err != nil {
+				log.Printf("notifier %d (%s) failed: %s", i, notifier.Name, err)
+			}
+		}(i, notifier)
+	}
+	wg.Wait()
+	return nil
+}
+
+// Close releases the notifiers which hold on to resources
+// such as a connection, they are closed when a session ends.
+func (m MultiNotifier) Close() error {
+	var err error
+	for _, notifier := range m {
+		closer, ok := notifier.Notifier.(io.Closer)
+		if !ok {
+			continue
+		}
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("notifier %s: %s", notifier.Name, closeErr)
+		}
+	}
+	return err
+}
+
+// writeIcon writes the built-in tomato
+// icon if it doesn't already exist.
+func writeIcon(iconPath string) {
+	_, err := os.Stat(iconPath)
+	if os.IsNotExist(err) {
+		raw := MustAsset("tomato-icon.png")
+		_ = ioutil.WriteFile(iconPath, raw, 0644)
+	}
+}
+
+// Xnotifier can push notifications to mac, linux and windows.
+type Xnotifier struct {
+	*notificator.Notificator
+	iconPath string
+}
+
+func NewXnotifier(iconPath string) Notifier {
+	writeIcon(iconPath)
+	return Xnotifier{
+		Notificator: notificator.New(notificator.Options{}),
+		iconPath:    iconPath,
+	}
+}
+
+func newXnotifier(config *Config, raw json.RawMessage) (Notifier, error) {
+	return NewXnotifier(config.IconPath), nil
+}
+
+// Notify sends a notification to the OS.
+func (n Xnotifier) Notify(notification Notification) error {
+	urgency := notificator.UR_NORMAL
+	if notification.Urgency == UrgencyCritical {
+		urgency = notificator.UR_CRITICAL
+	}
+	return n.Push(notification.Title, notification.Body, n.iconPath, urgency)
+}
+
+// DBusNotifier talks to the desktop notification server
+// directly over the session bus which allows it to show
+// action buttons and to choose how long notifications
+// are shown for.
+type DBusNotifier struct {
+	IconPath string
+	// Urgency overrides the urgency of every
+	// notification when it is set
+	Urgency Urgency
+	// Timeout is how long notifications are shown,
+	// the server decides when it is zero
+	Timeout time.Duration
+	// Actions offers the actions of notifications as buttons
+	Actions bool
+
+	mu       sync.Mutex
+	conn     *dbus.Conn
+	handlers map[uint32]func(string)
+}
+
+func newDBusNotifier(config *Config, raw json.RawMessage) (Notifier, error) {
+	options := struct {
+		Icon    string  `json:"icon"`
+		Urgency Urgency `json:"urgency"`
+		Timeout string  `json:"timeout"`
+		Actions *bool   `json:"actions"`
+	}{}
+	err := json.Unmarshal(raw, &options)
+	if err != nil {
+		return nil, err
+	}
+	notifier := &DBusNotifier{
+		IconPath: options.Icon,
+		Urgency:  options.Urgency,
+		Actions:  options.Actions == nil || *options.Actions,
+	}
+	if notifier.IconPath == "" {
+		notifier.IconPath = config.IconPath
+		writeIcon(notifier.IconPath)
+	}
+	if notifier.Urgency != "" && !notifier.Urgency.valid() {
+		return nil, fmt.Errorf("unknown urgency %q", notifier.Urgency)
+	}
+	if options.Timeout != "" {
+		notifier.Timeout, err = time.ParseDuration(options.Timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return notifier, nil
+}
+
+// connect opens the session bus on first use so
+// the notifier can be created without one.
+func (n *DBusNotifier) connect() (*dbus.Conn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn != nil {
+		return n.conn, nil
+	}
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+	err = conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(dbusNotificationsPath),
+		dbus.WithMatchInterface(dbusNotificationsInterface),
+	)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+	go n.listen(signals)
+	n.conn = conn
+	n.handlers = map[uint32]func(string){}
+	return conn, nil
+}
+
+// Close disconnects from the session bus, the actions of
+// notifications that are still shown are no longer handled.
+func (n *DBusNotifier) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		return nil
+	}
+	// closing the connection also ends listen
+	err := n.conn.Close()
+	n.conn = nil
+	n.handlers = nil
+	return err
+}
+
+// listen dispatches the actions chosen by the user
+// until the connection to the session bus is closed.
+func (n *DBusNotifier) listen(signals <-chan *dbus.Signal) {
+	for signal := range signals {
+		if len(signal.Body) < 2 {
+			continue
+		}
+		id, ok := signal.Body[0].(uint32)
+		if !ok {
+			continue
+		}
+		switch signal.Name {
+		case dbusNotificationsInterface + ".ActionInvoked":
+			key, _ := signal.Body[1].(string)
+			n.mu.Lock()
+			handler := n.handlers[id]
+			n.mu.Unlock()
+			if handler != nil {
+				go handler(key)
+			}
+		case dbusNotificationsInterface + ".NotificationClosed":
+			n.mu.Lock()
+			delete(n.handlers, id)
+			n.mu.Unlock()
+		}
+	}
+}
+
+func (n *DBusNotifier) Notify(notification Notification) error {
+	conn, err := n.connect()
+	if err != nil {
+		return err
+	}
+	urgency := notification.Urgency
+	if n.Urgency != "" {
+		urgency = n.Urgency
+	}
+	actions := []string{}
+	if n.Actions && notification.OnAction != nil {
+		for _, action := range notification.Actions {
+			actions = append(actions, action.Key, action.Label)
+		}
+	}
+	timeout := int32(-1)
+	if n.Timeout > 0 {
+		timeout = int32(n.Timeout / time.Millisecond)
+	}
+	var id uint32
+	err = conn.Object(dbusNotificationsName, dbusNotificationsPath).Call(
+		dbusNotificationsInterface+".Notify", 0,
+		"pomo",
+		uint32(0),
+		n.IconPath,
+		notification.Title,
+		notification.Body,
+		actions,
+		map[string]dbus.Variant{"urgency": dbus.MakeVariant(urgency.level())},
+		timeout,
+	).Store(&id)
+	if err != nil {
+		return err
+	}
+	if len(actions) > 0 {
+		n.mu.Lock()
+		// the notifier may have been closed meanwhile
+		if n.handlers != nil {
+			n.handlers[id] = notification.OnAction
+		}
+		n.mu.Unlock()
+	}
+	return nil
+}
+
+// BellNotifier rings the terminal bell, it can also send the
+// notification with the OSC 9 escape sequence understood by
+// terminals such as iTerm2, kitty and Windows Terminal.
+type BellNotifier struct {
+	// Device is the terminal written to
+	Device string
+	OSC9   bool
+}
+
+func newBellNotifier(config *Config, raw json.RawMessage) (Notifier, error) {
+	options := struct {
+		Device string `json:"device"`
+		OSC9   bool   `json:"osc9"`
+	}{Device: defaultBellDevice}
+	err := json.Unmarshal(raw, &options)
+	if err != nil {
+		return nil, err
+	}
+	return BellNotifier{Device: options.Device, OSC9: options.OSC9}, nil
+}
+
+func (n BellNotifier) Notify(notification Notification) error {
+	fp, err := os.OpenFile(n.Device, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	seq := "\a"
+	if n.OSC9 {
+		seq += fmt.Sprintf("\x1b]9;%s: %s\x07",
+			stripControl(notification.Title), stripControl(notification.Body))
+	}
+	_, err = fp.WriteString(seq)
+	return err
+}
+
+// stripControl removes characters which
+// would end an escape sequence early.
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// CommandNotifier runs a command with the title and body
+// of the notification appended to its arguments, they
+// are also set as POMO_TITLE, POMO_BODY and POMO_URGENCY
+// in its environment.
+type CommandNotifier struct {
+	Command []string
+	// Timeout is how long the command may run
+	Timeout time.Duration
+}
+
+func newCommandNotifier(config *Config, raw json.RawMessage) (Notifier, error) {
+	options := struct {
+		Command []string `json:"command"`
+		Timeout string   `json:"timeout"`
+	}{}
+	err := json.Unmarshal(raw, &options)
+	if err != nil {
+		return nil, err
+	}
+	if len(options.Command) == 0 {
+		return nil, fmt.Errorf("command is required")
+	}
+	notifier := CommandNotifier{
+		Command: options.Command,
+		Timeout: defaultCommandTimeout,
+	}
+	if options.Timeout != "" {
+		notifier.Timeout, err = time.ParseDuration(options.Timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return notifier, nil
+}
+
+func (n CommandNotifier) Notify(notification Notification) error {
+	args := append(append([]string{}, n.Command...), notification.Title, notification.Body)
+	_, err := runCommand(args, n.Timeout, []string{
+		fmt.Sprintf("POMO_TITLE=%s", notification.Title),
+		fmt.Sprintf("POMO_BODY=%s", notification.Body),
+		fmt.Sprintf("POMO_URGENCY=%s", notification.Urgency),
+	}, nil)
+	return err
+}
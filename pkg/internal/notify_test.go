@@ -0,0 +1,177 @@
+package pomo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewNotifier(t *testing.T) {
+	config := &Config{}
+	err := json.Unmarshal([]byte(`{"notifiers": [
+		{"type": "bell", "osc9": true},
+		{"type": "command", "command": ["notify-send"], "timeout": "5s"},
+		{"type": "dbus", "urgency": "critical", "timeout": "10s", "actions": false}
+	]}`), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier, err := NewNotifier(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi := notifier.(MultiNotifier)
+	if len(multi) != 3 {
+		t.Fatalf("expected 3 notifiers, got %d", len(multi))
+	}
+	if bell := multi[0].Notifier.(BellNotifier); !bell.OSC9 || bell.Device != defaultBellDevice {
+		t.Fatalf("unexpected bell notifier %+v", bell)
+	}
+	if dbus := multi[2].Notifier.(*DBusNotifier); dbus.Urgency != UrgencyCritical || dbus.Actions || dbus.Timeout.Seconds() != 10 {
+		t.Fatalf("unexpected dbus notifier %+v", dbus)
+	}
+	raw, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), `"osc9":true`) {
+		t.Fatalf("notifier options were not kept: %s", raw)
+	}
+
+	for _, raw := range []string{
+		`{"notifiers": [{"type": "pigeon"}]}`,
+		`{"notifiers": [{"type": "command"}]}`,
+		`{"notifiers": [{"type": "dbus", "urgency": "whenever"}]}`,
+	} {
+		config := &Config{}
+		if err := json.Unmarshal([]byte(raw), config); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewNotifier(config); err == nil {
+			t.Fatalf("expected an error for %s", raw)
+		}
+	}
+	if err := json.Unmarshal([]byte(`{"notifiers": [{}]}`), &Config{}); err == nil {
+		t.Fatal("expected an error for a notifier without a type")
+	}
+}
+
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(Notification) error { return fmt.Errorf("no display") }
+
+func TestNotifiers(t *testing.T) {
+	baseDir, _ := ioutil.TempDir("/tmp", "")
+	defer os.RemoveAll(baseDir)
+	output := path.Join(baseDir, "output")
+	device := path.Join(baseDir, "tty")
+	ioutil.WriteFile(device, nil, 0644)
+
+	buf := bytes.NewBuffer(nil)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	notifier := MultiNotifier{
+		{Name: "failing", Notifier: failingNotifier{}},
+		{Name: "command", Notifier: CommandNotifier{
+			Command: []string{"sh", "-c", `echo "$1 $2 $POMO_URGENCY" > ` + output, "sh"},
+			Timeout: defaultCommandTimeout,
+		}},
+		{Name: "bell", Notifier: BellNotifier{Device: device, OSC9: true}},
+	}
+	err := notifier.Notify(Notification{Title: "Pomo", Body: "Break\x1b is over!", Urgency: UrgencyCritical})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "notifier 0 (failing) failed: no display") {
+		t.Fatalf("failure was not logged: %q", buf.String())
+	}
+	raw, _ := ioutil.ReadFile(output)
+	if string(raw) != "Pomo Break\x1b is over! critical\n" {
+		t.Fatalf("unexpected command output %q", raw)
+	}
+	raw, _ = ioutil.ReadFile(device)
+	if string(raw) != "\a\x1b]9;Pomo: Break is over!\a" {
+		t.Fatalf("unexpected bell output %q", raw)
+	}
+
+	err = CommandNotifier{Command: []string{"sh", "-c", "echo oops; exit 1"}, Timeout: defaultCommandTimeout}.
+		Notify(Notification{})
+	if err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Fatalf("expected the command output in the error, got %v", err)
+	}
+}
+
+// blockingNotifier holds each notification until released
+type blockingNotifier struct {
+	sent    chan Notification
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (n blockingNotifier) Close() error {
+	close(n.closed)
+	return nil
+}
+
+func (n blockingNotifier) Notify(notification Notification) error {
+	n.sent <- notification
+	<-n.release
+	return nil
+}
+
+func TestTaskRunnerNotify(t *testing.T) {
+	runner, store, clock := initTestRunner(t, &Task{
+		Duration:   25 * time.Minute,
+		NPomodoros: 2,
+		Message:    "Test Task",
+	})
+	defer store.Close()
+	notifier := blockingNotifier{
+		sent:    make(chan Notification, 10),
+		release: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	runner.notifier = MultiNotifier{{Name: "blocking", Notifier: notifier}}
+
+	runner.Start()
+	waitState(t, runner, RUNNING)
+	clock.Advance(25 * time.Minute)
+	waitState(t, runner, BREAKING)
+	<-notifier.sent
+	// the session is not held up by a slow notifier
+	stopped := make(chan error)
+	go func() { stopped <- runner.Stop() }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stopping the session waited for the notifier")
+	}
+	select {
+	case <-runner.Done():
+		t.Fatal("the session concluded before its notifications were sent")
+	case <-notifier.closed:
+		t.Fatal("the notifier was closed before its notifications were sent")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(notifier.release)
+	<-runner.Done()
+	select {
+	case <-notifier.closed:
+	default:
+		t.Fatal("expected the notifier to be closed when the session concluded")
+	}
+	if notification := <-notifier.sent; notification.Body != "Pomo session has been stopped!" {
+		t.Fatalf("unexpected notification %q", notification.Body)
+	}
+}
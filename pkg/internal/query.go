@@ -0,0 +1,325 @@
+package pomo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TaskSort is the order of tasks returned by QueryTasks
+type TaskSort string
+
+const (
+	SortByID      TaskSort = "id"
+	SortByStart   TaskSort = "start"
+	SortByMessage TaskSort = "message"
+)
+
+// TaskProgress describes how many of the
+// planned pomodoros of a task were completed.
+type TaskProgress string
+
+const (
+	// ProgressNotStarted tasks have no pomodoros
+	ProgressNotStarted TaskProgress = "not-started"
+	// ProgressIncomplete tasks have fewer
+	// completed pomodoros than planned
+	ProgressIncomplete TaskProgress = "incomplete"
+	// ProgressComplete tasks have completed
+	// all of their planned pomodoros
+	ProgressComplete TaskProgress = "complete"
+)
+
+// TaskQuery filters the tasks returned by QueryTasks,
+// the zero value matches every task ordered by ID.
+type TaskQuery struct {
+	// Since and Until match tasks with a pomodoro started
+	// within the range, a zero time leaves it open.
+	Since time.Time
+	Until time.Time
+	// Tags matches tasks with any of the tags
+	// or with all of them if AllTags is set.
+	Tags    []string
+	AllTags bool
+	// Grep matches tasks whose message contains
+	// the substring ignoring case.
+	Grep     string
+	Progress TaskProgress
+	// States matches tasks in any of the states
+	States []TaskState
+	// Project matches tasks in the project
+	Project string
+	// Subtree matches the task with the ID
+	// and all of its sub-tasks if non-zero.
+	Subtree int
+	Limit   int
+	Offset  int
+	Sort    TaskSort
+	// Descending reverses the sort order
+	Descending bool
+}
+
+// where returns the conditions of the
+// query and their arguments.
+func (q TaskQuery) where() (string, []interface{}, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		condition := `EXISTS (SELECT 1 FROM pomodoro WHERE pomodoro.task_id = task.rowid`
+		if !q.Since.IsZero() {
+			condition += ` AND julianday(pomodoro.start) >= julianday(?)`
+			args = append(args, q.Since)
+		}
+		if !q.Until.IsZero() {
+			condition += ` AND julianday(pomodoro.start) < julianday(?)`
+			args = append(args, q.Until)
+		}
+		conditions = append(conditions, condition+")")
+	}
+	if len(q.Tags) > 0 {
+		condition := `task.rowid IN (
+		SELECT task_tag.task_id FROM task_tag
+		JOIN tag ON tag.rowid = task_tag.tag_id
+		WHERE tag.name IN (?` + strings.Repeat(",?", len(q.Tags)-1) + `)
+		GROUP BY task_tag.task_id`
+		tags := map[string]bool{}
+		for _, tag := range q.Tags {
+			args = append(args, tag)
+			tags[tag] = true
+		}
+		if q.AllTags {
+			condition += ` HAVING COUNT(DISTINCT tag.name) = ?`
+			args = append(args, len(tags))
+		}
+		conditions = append(conditions, condition+")")
+	}
+	if q.Grep != "" {
+		conditions = append(conditions, `instr(lower(task.message), lower(?)) > 0`)
+		args = append(args, q.Grep)
+	}
+	if len(q.States) > 0 {
+		for _, state := range q.States {
+			if !state.Valid() {
+				return "", nil, fmt.Errorf("unknown task state %q", state)
+			}
+			args = append(args, state)
+		}
+		conditions = append(conditions, `task.state IN (?`+strings.Repeat(",?", len(q.States)-1)+`)`)
+	}
+	if q.Project != "" {
+		conditions = append(conditions, `task.project_id = (SELECT rowid FROM project WHERE name = ?)`)
+		args = append(args, q.Project)
+	}
+	if q.Subtree != 0 {
+		conditions = append(conditions, `task.rowid IN (`+subtree+`)`)
+		args = append(args, q.Subtree)
+	}
+	completed := `(SELECT COUNT(*) FROM pomodoro WHERE pomodoro.task_id = task.rowid AND pomodoro.status = 'completed')`
+	switch q.Progress {
+	case "":
+	case ProgressNotStarted:
+		conditions = append(conditions, `NOT EXISTS (SELECT 1 FROM pomodoro WHERE pomodoro.task_id = task.rowid)`)
+	case ProgressIncomplete:
+		conditions = append(conditions,
+			`EXISTS (SELECT 1 FROM pomodoro WHERE pomodoro.task_id = task.rowid)`,
+			completed+` < task.pomodoros`)
+	case ProgressComplete:
+		conditions = append(conditions, completed+` >= task.pomodoros`)
+	default:
+		return "", nil, fmt.Errorf("unknown task progress %q", q.Progress)
+	}
+	if len(conditions) == 0 {
+		return "", nil, nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// sortKey returns the expression tasks are ordered by
+func (q TaskQuery) sortKey() (string, error) {
+	switch q.Sort {
+	case SortByID, "":
+		return `task.rowid`, nil
+	case SortByStart:
+		return `(SELECT MIN(julianday(pomodoro.start)) FROM pomodoro WHERE pomodoro.task_id = task.rowid)`, nil
+	case SortByMessage:
+		return `lower(task.message)`, nil
+	}
+	return "", fmt.Errorf("cannot sort tasks by %q", q.Sort)
+}
+
+// QueryTasks returns the tasks matching the query along with their
+// tags, pomodoros and breaks. Tasks are filtered, sorted and paged
+// in a subquery which is joined with their pomodoros so the
+// history is read with a single statement.
+func (s Store) QueryTasks(tx *sql.Tx, query TaskQuery) ([]*Task, error) {
+	where, args, err := query.where()
+	if err != nil {
+		return nil, err
+	}
+	sortKey, err := query.sortKey()
+	if err != nil {
+		return nil, err
+	}
+	order := "ASC"
+	if query.Descending {
+		order = "DESC"
+	}
+	page := ""
+	if query.Limit > 0 || query.Offset > 0 {
+		limit := query.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		page = "LIMIT ? OFFSET ?"
+		args = append(args, limit, query.Offset)
+	}
+	rows, err := tx.Query(fmt.Sprintf(`
+	SELECT task.id, task.message, task.state, task.pomodoros, task.duration, task.project, task.parent_id, task.finished,
+		(SELECT json_group_array(name) FROM (
+			SELECT tag.name FROM task_tag
+			JOIN tag ON tag.rowid = task_tag.tag_id
+			WHERE task_tag.task_id = task.id
+			ORDER BY task_tag.rowid)),
+		(SELECT json_group_array(json_object('start', start, 'end', end, 'planned', planned)) FROM (
+			SELECT start, end, planned FROM break
+			WHERE break.task_id = task.id
+			ORDER BY break.rowid)),
+		pomodoro.id, pomodoro.start, pomodoro.end, pomodoro.status,
+		(SELECT json_group_array(json_object('start', start, 'end', end)) FROM (
+			SELECT start, end FROM pause
+			WHERE pause.pomodoro_id = pomodoro.id
+			ORDER BY pause.start))
+	FROM (
+		SELECT task.rowid AS id, task.message, task.state, task.pomodoros, task.duration,
+			project.name AS project, task.parent_id, task.finished, %[1]s AS sort_key
+		FROM task LEFT JOIN project ON project.rowid = task.project_id %[2]s
+		ORDER BY sort_key %[3]s, task.rowid %[3]s %[4]s
+	) AS task
+	LEFT JOIN pomodoro ON pomodoro.task_id = task.id
+	ORDER BY task.sort_key %[3]s, task.id %[3]s, pomodoro.id`, sortKey, where, order, page), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tasks := []*Task{}
+	var task *Task
+	for rows.Next() {
+		var (
+			taskID      int
+			message     string
+			state       TaskState
+			nPomodoros  int
+			strDuration string
+			project     sql.NullString
+			parentID    sql.NullInt64
+			finished    *time.Time
+			rawTags     string
+			rawBreaks   string
+			pomodoroID  sql.NullInt64
+			startStr    sql.NullString
+			endStr      sql.NullString
+			status      sql.NullString
+			rawPauses   sql.NullString
+		)
+		err = rows.Scan(
+			&taskID, &message, &state, &nPomodoros, &strDuration, &project, &parentID, &finished, &rawTags, &rawBreaks,
+			&pomodoroID, &startStr, &endStr, &status, &rawPauses,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if task == nil || task.ID != taskID {
+			duration, _ := time.ParseDuration(strDuration)
+			task = &Task{
+				ID:         taskID,
+				Message:    message,
+				State:      state,
+				NPomodoros: nPomodoros,
+				Duration:   duration,
+				Project:    project.String,
+				ParentID:   int(parentID.Int64),
+				Finished:   finished,
+				Pomodoros:  []*Pomodoro{},
+			}
+			err = json.Unmarshal([]byte(rawTags), &task.Tags)
+			if err != nil {
+				return nil, err
+			}
+			if len(task.Tags) == 0 {
+				task.Tags = nil
+			}
+			task.Breaks, err = decodeBreaks(rawBreaks)
+			if err != nil {
+				return nil, err
+			}
+			tasks = append(tasks, task)
+		}
+		if !pomodoroID.Valid {
+			// task without any pomodoros
+			continue
+		}
+		start, _ := time.Parse(datetimeFmt, startStr.String)
+		end, _ := time.Parse(datetimeFmt, endStr.String)
+		pomodoro := &Pomodoro{
+			ID:     int(pomodoroID.Int64),
+			Start:  start,
+			End:    end,
+			Status: PomodoroStatus(status.String),
+		}
+		pomodoro.Pauses, err = decodePauses(rawPauses.String)
+		if err != nil {
+			return nil, err
+		}
+		task.Pomodoros = append(task.Pomodoros, pomodoro)
+	}
+	return tasks, rows.Err()
+}
+
+// storedInterval is an interval read
+// from a JSON aggregate of a query.
+type storedInterval struct {
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Planned string `json:"planned"`
+}
+
+func decodeIntervals(raw string) ([]storedInterval, error) {
+	intervals := []storedInterval{}
+	err := json.Unmarshal([]byte(raw), &intervals)
+	return intervals, err
+}
+
+func decodeBreaks(raw string) ([]*Break, error) {
+	intervals, err := decodeIntervals(raw)
+	if err != nil {
+		return nil, err
+	}
+	breaks := []*Break{}
+	for _, interval := range intervals {
+		b := &Break{}
+		b.Start, _ = time.Parse(datetimeFmt, interval.Start)
+		b.End, _ = time.Parse(datetimeFmt, interval.End)
+		b.Planned, _ = time.ParseDuration(interval.Planned)
+		breaks = append(breaks, b)
+	}
+	return breaks, nil
+}
+
+func decodePauses(raw string) ([]*Pause, error) {
+	intervals, err := decodeIntervals(raw)
+	if err != nil {
+		return nil, err
+	}
+	pauses := []*Pause{}
+	for _, interval := range intervals {
+		pause := &Pause{}
+		pause.Start, _ = time.Parse(datetimeFmt, interval.Start)
+		pause.End, _ = time.Parse(datetimeFmt, interval.End)
+		pauses = append(pauses, pause)
+	}
+	return pauses, nil
+}
@@ -0,0 +1,228 @@
+package pomo
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Grouping determines how pomodoros
+// are aggregated in a Report.
+type Grouping string
+
+const (
+	ByDay   Grouping = "day"
+	ByWeek  Grouping = "week"
+	ByMonth Grouping = "month"
+	ByTag   Grouping = "tag"
+	// ByProject groups pomodoros by the project of their task
+	ByProject Grouping = "project"
+	// ByTree rolls up the pomodoros of sub-tasks
+	// into the top level task they belong to.
+	ByTree Grouping = "tree"
+)
+
+// ungrouped groups tasks without any tags or project
+const ungrouped = "-"
+
+// key returns the group a pomodoro belongs to, ByTag, ByProject
+// and ByTree are handled separately as they depend on the task.
+func (g Grouping) key(t time.Time) string {
+	t = t.Local()
+	switch g {
+	case ByWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case ByMonth:
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
+
+// ReportRow aggregates the pomodoros of a single group
+type ReportRow struct {
+	Key string `json:"key"`
+	// Planned is the number of pomodoros planned for tasks
+	// started in the group, date groups count a task in
+	// the group of its first pomodoro.
+	Planned     int `json:"planned"`
+	Completed   int `json:"completed"`
+	Interrupted int `json:"interrupted"`
+	Abandoned   int `json:"abandoned"`
+	// Focused is the time worked excluding pauses
+	Focused time.Duration `json:"focused"`
+}
+
+// InterruptionRate is the fraction of pomodoros
+// that were interrupted or abandoned.
+func (r ReportRow) InterruptionRate() float64 {
+	started := r.Completed + r.Interrupted + r.Abandoned
+	if started == 0 {
+		return 0
+	}
+	return float64(r.Interrupted+r.Abandoned) / float64(started)
+}
+
+// MarshalJSON includes the interruption rate
+func (r ReportRow) MarshalJSON() ([]byte, error) {
+	type row ReportRow
+	return json.Marshal(struct {
+		row
+		InterruptionRate float64 `json:"interruption_rate"`
+	}{row(r), r.InterruptionRate()})
+}
+
+func (r *ReportRow) add(pomodoro *Pomodoro) {
+	switch pomodoro.Status {
+	case PomodoroInterrupted:
+		r.Interrupted++
+	case PomodoroAbandoned:
+		r.Abandoned++
+	default:
+		r.Completed++
+	}
+	r.Focused += pomodoro.Worked()
+}
+
+// Report summarizes the pomodoros of
+// many tasks grouped by date or tag.
+type Report struct {
+	By    Grouping     `json:"by"`
+	Rows  []*ReportRow `json:"rows"`
+	Total ReportRow    `json:"total"`
+}
+
+// NewReport aggregates the pomodoros of the given tasks
+// started at or after since, a zero since includes all.
+func NewReport(tasks []*Task, by Grouping, since time.Time) (*Report, error) {
+	switch by {
+	case ByDay, ByWeek, ByMonth, ByTag, ByProject, ByTree:
+	default:
+		return nil, fmt.Errorf("cannot group report by %q", by)
+	}
+	report := &Report{By: by, Total: ReportRow{Key: "total"}}
+	rows := map[string]*ReportRow{}
+	row := func(key string) *ReportRow {
+		if _, ok := rows[key]; !ok {
+			rows[key] = &ReportRow{Key: key}
+			report.Rows = append(report.Rows, rows[key])
+		}
+		return rows[key]
+	}
+	// keys of the tasks grouped by project or tree
+	// and the order of the top level tasks
+	keys := map[int]string{}
+	order := map[string]int{}
+	for i, root := range NewTaskTree(tasks) {
+		key := fmt.Sprintf("%d: %s", root.Task.ID, root.Task.Message)
+		order[key] = i
+		root.Walk(func(node *TaskNode, _ int) {
+			switch by {
+			case ByProject:
+				keys[node.Task.ID] = node.Task.Project
+				if node.Task.Project == "" {
+					keys[node.Task.ID] = ungrouped
+				}
+			case ByTree:
+				keys[node.Task.ID] = key
+			}
+		})
+	}
+	for _, task := range tasks {
+		var pomodoros []*Pomodoro
+		for _, pomodoro := range task.Pomodoros {
+			if !pomodoro.Start.Before(since) {
+				pomodoros = append(pomodoros, pomodoro)
+			}
+		}
+		if len(pomodoros) == 0 {
+			continue
+		}
+		report.Total.Planned += task.NPomodoros
+		for _, pomodoro := range pomodoros {
+			report.Total.add(pomodoro)
+		}
+		if by == ByTag {
+			tags := task.Tags
+			if len(tags) == 0 {
+				tags = []string{ungrouped}
+			}
+			for _, tag := range tags {
+				r := row(tag)
+				r.Planned += task.NPomodoros
+				for _, pomodoro := range pomodoros {
+					r.add(pomodoro)
+				}
+			}
+			continue
+		}
+		if key, ok := keys[task.ID]; ok {
+			r := row(key)
+			r.Planned += task.NPomodoros
+			for _, pomodoro := range pomodoros {
+				r.add(pomodoro)
+			}
+			continue
+		}
+		row(by.key(pomodoros[0].Start)).Planned += task.NPomodoros
+		for _, pomodoro := range pomodoros {
+			row(by.key(pomodoro.Start)).add(pomodoro)
+		}
+	}
+	if by == ByTree {
+		sort.Slice(report.Rows, func(i, j int) bool {
+			return order[report.Rows[i].Key] < order[report.Rows[j].Key]
+		})
+		return report, nil
+	}
+	sort.Slice(report.Rows, func(i, j int) bool {
+		return report.Rows[i].Key < report.Rows[j].Key
+	})
+	return report, nil
+}
+
+// WriteText writes the report as an aligned table
+func (r Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tCOMPLETED\tPLANNED\tINTERRUPTED\tFOCUSED\tRATE\n", strings.ToUpper(string(r.By)))
+	for _, row := range append(r.Rows, &r.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%.0f%%\n",
+			row.Key,
+			row.Completed,
+			row.Planned,
+			row.Interrupted+row.Abandoned,
+			row.Focused.Truncate(time.Minute),
+			row.InterruptionRate()*100,
+		)
+	}
+	return tw.Flush()
+}
+
+// WriteCSV writes the report with a header row,
+// focused time is given in hours.
+func (r Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{
+		string(r.By), "completed", "planned", "interrupted",
+		"abandoned", "focused_hours", "interruption_rate",
+	})
+	for _, row := range append(r.Rows, &r.Total) {
+		writer.Write([]string{
+			row.Key,
+			strconv.Itoa(row.Completed),
+			strconv.Itoa(row.Planned),
+			strconv.Itoa(row.Interrupted),
+			strconv.Itoa(row.Abandoned),
+			strconv.FormatFloat(row.Focused.Hours(), 'f', 2, 64),
+			strconv.FormatFloat(row.InterruptionRate(), 'f', 2, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
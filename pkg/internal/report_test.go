@@ -0,0 +1,111 @@
+package pomo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	day := time.Date(2022, 1, 3, 9, 0, 0, 0, time.Local)
+	pomodoro := func(start time.Time, status PomodoroStatus) *Pomodoro {
+		return &Pomodoro{Start: start, End: start.Add(25 * time.Minute), Status: status}
+	}
+	tasks := []*Task{
+		{
+			NPomodoros: 2,
+			Tags:       []string{"work", "code"},
+			Pomodoros: []*Pomodoro{
+				pomodoro(day, PomodoroCompleted),
+				pomodoro(day.Add(time.Hour), PomodoroInterrupted),
+			},
+		},
+		{
+			NPomodoros: 3,
+			Pomodoros: []*Pomodoro{
+				pomodoro(day.AddDate(0, 0, 7), PomodoroCompleted),
+				pomodoro(day.AddDate(0, 0, 8), PomodoroCompleted),
+			},
+		},
+		// never started
+		{NPomodoros: 4},
+	}
+
+	report, err := NewReport(tasks, ByDay, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rows) != 3 {
+		t.Fatalf("expected 3 days, got %d", len(report.Rows))
+	}
+	first := report.Rows[0]
+	if first.Key != "2022-01-03" || first.Planned != 2 || first.Completed != 1 || first.Interrupted != 1 {
+		t.Fatalf("unexpected first day %v", first)
+	}
+	if first.InterruptionRate() != 0.5 || first.Focused != 50*time.Minute {
+		t.Fatalf("unexpected rate %f and focus %s", first.InterruptionRate(), first.Focused)
+	}
+	if report.Rows[2].Planned != 0 || report.Rows[2].Completed != 1 {
+		t.Fatalf("expected planned pomodoros only on the first day, got %v", report.Rows[2])
+	}
+	if report.Total.Planned != 5 || report.Total.Completed != 3 {
+		t.Fatalf("unexpected total %v", report.Total)
+	}
+
+	report, err = NewReport(tasks, ByWeek, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rows) != 1 || report.Rows[0].Key != "2022-W02" || report.Rows[0].Completed != 2 {
+		t.Fatalf("unexpected weeks %v", report.Rows)
+	}
+
+	report, err = NewReport(tasks, ByTag, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, row := range report.Rows {
+		keys = append(keys, row.Key)
+	}
+	if strings.Join(keys, ",") != "-,code,work" {
+		t.Fatalf("unexpected tags %v", keys)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := report.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 || lines[4] != "total,3,5,1,0,1.67,0.25" {
+		t.Fatalf("unexpected csv %q", lines)
+	}
+
+	tasks[0].ID, tasks[0].Project = 1, "web"
+	tasks[1].ID, tasks[1].ParentID = 2, 3
+	tasks[2].ID, tasks[2].Project = 3, "web"
+	report, err = NewReport(tasks, ByProject, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rows) != 2 || report.Rows[0].Key != "-" || report.Rows[1].Key != "web" ||
+		report.Rows[1].Completed != 1 || report.Rows[1].Planned != 2 {
+		t.Fatalf("unexpected projects %v %v", report.Rows[0], report.Rows[1])
+	}
+
+	// the pomodoros of task 2 are rolled up into task 3
+	// even though the parent itself was never started
+	report, err = NewReport(tasks, ByTree, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rows) != 2 || report.Rows[0].Key != "1: " || report.Rows[1].Key != "3: " ||
+		report.Rows[1].Completed != 2 || report.Rows[1].Planned != 3 {
+		t.Fatalf("unexpected trees %v %v", report.Rows[0], report.Rows[1])
+	}
+
+	if _, err := NewReport(tasks, Grouping("year"), time.Time{}); err == nil {
+		t.Fatal("expected an unknown grouping to fail")
+	}
+}
@@ -2,202 +2,912 @@ package pomo
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
-	"os/exec"
+	"path"
+	"strings"
 	"sync"
 	"time"
 )
 
+// tickInterval is how often the event loop checks
+// whether a checkpoint or the metrics are due.
+const tickInterval = time.Second
+
+// checkpointInterval is how often the progress of a running
+// pomodoro is saved to the store between changes of state,
+// at most this much of it is lost if the process dies.
+const checkpointInterval = time.Minute
+
+// subscriberBuffer is the number of events buffered for
+// each subscriber, events are dropped for subscribers
+// that fall further behind.
+const subscriberBuffer = 64
+
+// ErrSessionConcluded is returned for commands
+// sent to a runner whose session has ended.
+var ErrSessionConcluded = errors.New("session has concluded")
+
+type commandKind int
+
+const (
+	pauseCmd commandKind = iota
+	resumeCmd
+	togglePauseCmd
+	skipCmd
+	stopCmd
+	extendCmd
+	finishCmd
+)
+
+// runnerCommand is handled by the event loop
+// which replies once it has been applied.
+type runnerCommand struct {
+	kind commandKind
+	// n is the number of pomodoros to extend the session by
+	n     int
+	reply chan error
+}
+
+// rejectedError is returned for a command that cannot be
+// applied in the current state, unlike any other error
+// from handle it does not end the session.
+type rejectedError struct{ error }
+
+// snapshot is an immutable copy of the runner state
+// published by the event loop after each change.
+type snapshot struct {
+	state     State
+	count     int
+	target    int
+	started   time.Time
+	stopped   time.Time
+	duration  time.Duration
+	remaining time.Duration
+}
+
+// TaskRunner runs a session of pomodoros for a single task.
+// All session state is owned by a single event loop started
+// with Start, other goroutines interact with it through
+// commands, immutable status snapshots and event subscriptions.
 type TaskRunner struct {
-	count        int
-	taskID       int
-	taskMessage  string
+	taskID      int
+	taskMessage string
+	// nPomodoros is the estimate of the task, the
+	// session may be extended past it or finished early
 	nPomodoros   int
 	origDuration time.Duration
-	state        State
 	store        *Store
-	started      time.Time
-	stopped      time.Time
-	pause        chan bool
-	toggle       chan bool
 	notifier     Notifier
-	duration     time.Duration
-	mu           sync.Mutex
-	onEvent      []string
+	breaks       Breaks
+	restored     *Checkpoint
+	clock        Clock
+	hooks        []Hook
+	outbox       *Outbox
+	metricsPath  string
+	metrics      chan *Status
+	commands     chan runnerCommand
+	done         chan struct{}
+	err          error
+
+	// owned by the event loop
+	state State
+	count int
+	// target is the number of pomodoros after
+	// which the session completes
+	target    int
+	started   time.Time
+	stopped   time.Time
+	duration  time.Duration
+	remaining time.Duration
+	timer     Timer
+	pomodoro  *Pomodoro
+	pause     *Pause
+	brk       *Break
+	// checkpointed is when the last checkpoint was written
+	checkpointed time.Time
+	// metricsSent is when the status was last sent to metrics
+	metricsSent time.Time
+
+	mu          sync.RWMutex
+	snapshot    snapshot
+	subscribers map[int]chan Event
+	nextSubID   int
+	// notifying counts notifications still being sent
+	notifying sync.WaitGroup
 }
 
-func NewMockedTaskRunner(task *Task, store *Store, notifier Notifier) (*TaskRunner, error) {
+func NewMockedTaskRunner(task *Task, store *Store, notifier Notifier, clock Clock) (*TaskRunner, error) {
 	tr := &TaskRunner{
 		taskID:       task.ID,
 		taskMessage:  task.Message,
 		nPomodoros:   task.NPomodoros,
+		target:       task.NPomodoros,
 		origDuration: task.Duration,
 		store:        store,
 		state:        CREATED,
-		pause:        make(chan bool),
-		toggle:       make(chan bool),
+		commands:     make(chan runnerCommand),
+		done:         make(chan struct{}),
+		subscribers:  map[int]chan Event{},
 		notifier:     notifier,
 		duration:     task.Duration,
+		breaks:       *DefaultBreaks(),
+		clock:        clock,
 	}
+	tr.publish()
 	return tr, nil
 }
-func NewTaskRunner(task *Task, config *Config) (*TaskRunner, error) {
+
+func NewTaskRunner(task *Task, config *Config, clock Clock) (*TaskRunner, error) {
+	notifier, err := NewNotifier(config)
+	if err != nil {
+		return nil, err
+	}
 	store, err := NewStore(config.DBPath)
 	if err != nil {
 		return nil, err
 	}
+	var outbox *Outbox
+	if len(config.Webhooks) > 0 {
+		outbox, err = NewOutbox(path.Join(config.BasePath, "outbox"), config.Webhooks, clock)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
 	tr := &TaskRunner{
-		count:        len(task.Pomodoros),
+		count:        task.Completed(),
 		taskID:       task.ID,
 		taskMessage:  task.Message,
 		nPomodoros:   task.NPomodoros,
+		target:       task.NPomodoros,
 		origDuration: task.Duration,
 		store:        store,
-		state:        State(0),
-		pause:        make(chan bool),
-		toggle:       make(chan bool),
-		notifier:     NewXnotifier(config.IconPath),
+		state:        CREATED,
+		commands:     make(chan runnerCommand),
+		done:         make(chan struct{}),
+		subscribers:  map[int]chan Event{},
+		notifier:     notifier,
 		duration:     task.Duration,
-		onEvent:      config.OnEvent,
+		breaks:       *config.Breaks,
+		hooks:        config.hooks(),
+		outbox:       outbox,
+		metricsPath:  config.MetricsPath,
+		clock:        clock,
 	}
+	tr.publish()
 	return tr, nil
 }
 
+// Start launches the event loop, it must be
+// called before any commands are sent.
 func (t *TaskRunner) Start() {
-	go t.run()
+	go func() {
+		defer t.closeSubscribers()
+		defer close(t.done)
+		defer t.closeNotifier()
+		// the last notifications are sent before Done
+		// is closed and the process may exit
+		defer t.notifying.Wait()
+		if t.outbox != nil {
+			t.outbox.Start()
+			// deliver the events of the end of the session
+			defer t.outbox.Stop()
+		}
+		if t.metricsPath != "" {
+			defer t.startMetrics()()
+		}
+		t.err = t.run()
+	}()
+}
+
+// Done is closed once the session has concluded
+func (t *TaskRunner) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns the error that caused the session
+// to conclude, it is only valid after Done is closed.
+func (t *TaskRunner) Err() error {
+	return t.err
+}
+
+// Restore continues the session from a checkpoint
+// rather than starting a new pomodoro, it must be
+// called before Start.
+func (t *TaskRunner) Restore(checkpoint *Checkpoint) error {
+	if checkpoint.TaskID != t.taskID {
+		return fmt.Errorf("checkpoint is for task %d, not %d", checkpoint.TaskID, t.taskID)
+	}
+	t.count = checkpoint.Count
+	if checkpoint.Target > 0 {
+		t.target = checkpoint.Target
+	}
+	t.restored = checkpoint
+	t.publish()
+	return nil
+}
+
+// Subscribe returns a channel receiving each event emitted by
+// the runner and a function that cancels the subscription. The
+// channel is closed once the session concludes.
+func (t *TaskRunner) Subscribe() (<-chan Event, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ch := make(chan Event, subscriberBuffer)
+	select {
+	case <-t.done:
+		close(ch)
+		return ch, func() {}
+	default:
+	}
+	id := t.nextSubID
+	t.nextSubID++
+	t.subscribers[id] = ch
+	return ch, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if ch, ok := t.subscribers[id]; ok {
+			delete(t.subscribers, id)
+			close(ch)
+		}
+	}
 }
 
-func (t *TaskRunner) TimeRemaining() time.Duration {
-	return (t.duration - time.Since(t.started)).Truncate(time.Second)
+func (t *TaskRunner) closeSubscribers() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, ch := range t.subscribers {
+		delete(t.subscribers, id)
+		close(ch)
+	}
 }
 
-func (t *TaskRunner) TimePauseDuration() time.Duration {
-	return (time.Since(t.stopped)).Truncate(time.Second)
+// emit delivers an event to every subscriber
+// without blocking the event loop.
+func (t *TaskRunner) emit(eventType EventType) {
+	event := Event{
+		Type:   eventType,
+		Time:   t.clock.Now(),
+		Status: *t.Status(),
+	}
+	switch eventType {
+	case EventPomodoroStarted, EventPomodoroEnded, EventPaused, EventResumed:
+		pomodoro := *t.pomodoro
+		pomodoro.Pauses = append([]*Pause{}, t.pomodoro.Pauses...)
+		event.Pomodoro = &pomodoro
+	case EventBreakStarted, EventBreakEnded:
+		brk := *t.brk
+		event.Break = &brk
+	}
+	t.mu.RLock()
+	for _, ch := range t.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	t.mu.RUnlock()
+	t.dispatch(event)
 }
 
-func (t *TaskRunner) SetState(state State) {
+// publish copies the loop owned state into
+// the snapshot read by other goroutines.
+func (t *TaskRunner) publish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.snapshot = snapshot{
+		state:     t.state,
+		count:     t.count,
+		target:    t.target,
+		started:   t.started,
+		stopped:   t.stopped,
+		duration:  t.duration,
+		remaining: t.remaining,
+	}
+}
+
+func (t *TaskRunner) setState(state State) {
 	t.state = state
-	// execute onEvent command if variable is set
-	if t.onEvent != nil {
-		go t.runOnEvent()
-	}
-}
-
-// execute script command specified by `onEvent` on state change
-func (t *TaskRunner) runOnEvent() error {
-	var cmd *exec.Cmd
-	// parse command arguments
-	numArgs := len(t.onEvent)
-	app := t.onEvent[0]
-	if numArgs > 1 {
-		args := t.onEvent[1:(numArgs + 1)]
-		cmd = exec.Command(app, args...)
-	} else {
-		cmd = exec.Command(app)
-	}
-	// set state in command environment
-	cmd.Env = append(os.Environ(),
-		fmt.Sprintf("POMO_STATE=%s", t.state),
-	)
-	// run command
-	err := cmd.Run()
+	t.publish()
+}
+
+// dispatch runs the hooks configured for the event and queues
+// it for the webhooks, failures are written to the log.
+func (t *TaskRunner) dispatch(event Event) {
+	var hooks []Hook
+	for _, hook := range t.hooks {
+		if hook.Matches(event.Type) {
+			hooks = append(hooks, hook)
+		}
+	}
+	webhooks := t.outbox != nil && t.outbox.Matches(event.Type)
+	if len(hooks) == 0 && !webhooks {
+		return
+	}
+	payload := HookPayload{Event: event}
+	err := t.store.With(func(tx *sql.Tx) error {
+		task, err := t.store.ReadTask(tx, t.taskID)
+		payload.Task = task
+		return err
+	})
+	if err != nil {
+		log.Printf("failed to read task %d for hooks: %s", t.taskID, err)
+	}
+	if webhooks {
+		err = t.outbox.Enqueue(payload)
+		if err != nil {
+			log.Printf("failed to queue %s for webhooks: %s", event.Type, err)
+		}
+	}
+	for _, hook := range hooks {
+		if hook.Block {
+			runHook(hook, payload)
+		} else {
+			go runHook(hook, payload)
+		}
+	}
+}
+
+func runHook(hook Hook, payload HookPayload) {
+	command := quoteCommand(hook.Command)
+	output, err := hook.Run(payload)
 	if err != nil {
+		log.Printf("hook %s on %s failed: %s", command, payload.Event.Type, err)
+		return
+	}
+	if msg := strings.TrimSpace(string(output)); msg != "" {
+		log.Printf("hook %s on %s: %s", command, payload.Event.Type, msg)
+	}
+}
+
+// startMetrics rewrites the metrics textfile in the background
+// with each status sent by the event loop so the loop never waits
+// on the store, the returned func writes the final metrics once
+// the session has concluded.
+func (t *TaskRunner) startMetrics() (stop func()) {
+	t.metrics = make(chan *Status, 1)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for status := range t.metrics {
+			t.writeMetrics(status)
+		}
+	}()
+	return func() {
+		t.sendMetrics(nil)
+		close(t.metrics)
+		<-stopped
+	}
+}
+
+// sendMetrics replaces any status the
+// metrics have not been written for yet
+func (t *TaskRunner) sendMetrics(status *Status) {
+	select {
+	case <-t.metrics:
+	default:
+	}
+	t.metrics <- status
+}
+
+// writeMetrics rewrites the metrics textfile, status
+// is nil once the session has concluded.
+func (t *TaskRunner) writeMetrics(status *Status) {
+	var metrics *Metrics
+	err := t.store.With(func(tx *sql.Tx) error {
+		var err error
+		metrics, err = t.store.ReadMetrics(tx)
 		return err
+	})
+	if err == nil {
+		metrics.Status = status
+		err = metrics.WriteFile(t.metricsPath)
+	}
+	if err != nil {
+		log.Printf("failed to write metrics to %s: %s", t.metricsPath, err)
 	}
-	return nil
 }
 
+// run is the event loop which owns all session state
 func (t *TaskRunner) run() error {
-	for t.count < t.nPomodoros {
-		// Create a new pomodoro where we
-		// track the start / end time of
-		// of this session.
-		pomodoro := &Pomodoro{}
-		// Start this pomodoro
-		pomodoro.Start = time.Now()
-		// Set state to RUNNIN
-		t.SetState(RUNNING)
-		// Create a new timer
-		timer := time.NewTimer(t.duration)
-		// Record our started time
-		t.started = pomodoro.Start
-	loop:
+	t.timer = t.clock.NewTimer(time.Hour)
+	t.timer.Stop()
+	defer t.timer.Stop()
+	ticker := t.clock.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	err := t.begin()
+	if err != nil {
+		return err
+	}
+	for t.state != COMPLETE {
 		select {
-		case <-timer.C:
-			t.stopped = time.Now()
-			t.count++
-		case <-t.toggle:
-			// Catch any toggles when we
-			// are not expecting them
-			goto loop
-		case <-t.pause:
-			timer.Stop()
-			// Record the remaining time of the current pomodoro
-			remaining := t.TimeRemaining()
-			// Change state to PAUSED
-			t.SetState(PAUSED)
-			// Wait for the user to press [p]
-			<-t.pause
-			// Resume the timer with previous
-			// remaining time
-			timer.Reset(remaining)
-			// Change duration
-			t.started = time.Now()
-			t.duration = remaining
-			// Restore state to RUNNING
-			t.SetState(RUNNING)
-			goto loop
-		}
-		pomodoro.End = time.Now()
-		err := t.store.With(func(tx *sql.Tx) error {
-			return t.store.CreatePomodoro(tx, t.taskID, *pomodoro)
+		case cmd := <-t.commands:
+			err = t.handle(cmd)
+			cmd.reply <- err
+			if _, rejected := err.(rejectedError); rejected {
+				err = nil
+			}
+		case <-t.timer.C():
+			err = t.expire()
+		case <-ticker.C():
+			if t.state == RUNNING && t.clock.Since(t.checkpointed) >= checkpointInterval {
+				t.remaining = t.duration - t.clock.Since(t.started)
+				err = t.checkpoint()
+			}
+			if t.metrics != nil && t.clock.Since(t.metricsSent) >= metricsInterval {
+				t.metricsSent = t.clock.Now()
+				t.sendMetrics(t.Status())
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// begin starts the first pomodoro or restores
+// the session from its checkpoint.
+func (t *TaskRunner) begin() error {
+	err := t.store.With(func(tx *sql.Tx) error {
+		current, err := t.store.ReadCheckpoint(tx)
+		if err != nil || current == nil {
+			return err
+		}
+		// The checkpoint of a session still running in another
+		// process must not be abandoned or restored twice.
+		if current.PID != os.Getpid() && processAlive(current.PID) {
+			return fmt.Errorf("a session of task %d is running with pid %d", current.TaskID, current.PID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	restored := t.restored
+	if restored == nil {
+		// Any checkpoint left behind belongs to a
+		// session that did not shut down cleanly.
+		err = t.store.With(t.store.AbandonCheckpoint, func(tx *sql.Tx) error {
+			return t.store.SetTaskState(tx, t.taskID, TaskActive, t.clock.Now())
+		})
+		if err != nil {
+			return err
+		}
+		if t.count >= t.target {
+			// the estimate was already reached, continue
+			// the task with one more pomodoro
+			t.target = t.count + 1
+		}
+		return t.startPomodoro()
+	}
+	switch restored.State {
+	case BREAKING:
+		return t.startBreak(&Break{
+			Start:   restored.Started,
+			Planned: restored.Duration,
 		})
+	case RUNNING, PAUSED:
+		// Continue the pomodoro from the checkpoint, the
+		// time since it was written was not spent working.
+		t.pomodoro = &Pomodoro{
+			Start:  restored.Started,
+			Status: PomodoroCompleted,
+			Pauses: restored.Pauses,
+		}
+		t.pause = &Pause{Start: restored.Updated}
+		t.remaining = restored.Remaining
+		if restored.State == PAUSED {
+			t.setState(PAUSED)
+			t.emit(EventPaused)
+			return t.checkpoint()
+		}
+		return t.resumePomodoro()
+	}
+	return fmt.Errorf("cannot restore session from state %s", restored.State)
+}
+
+// handle applies a command, the error is
+// returned to the sender of the command.
+func (t *TaskRunner) handle(cmd runnerCommand) error {
+	var err error
+	switch cmd.kind {
+	case pauseCmd:
+		if t.state != RUNNING {
+			return rejectedError{fmt.Errorf("cannot pause while %s", t.state)}
+		}
+		err = t.pausePomodoro()
+	case resumeCmd:
+		if t.state != PAUSED {
+			return rejectedError{fmt.Errorf("cannot resume while %s", t.state)}
+		}
+		err = t.resumePomodoro()
+	case togglePauseCmd:
+		switch t.state {
+		case RUNNING:
+			err = t.pausePomodoro()
+		case PAUSED:
+			err = t.resumePomodoro()
+		default:
+			return rejectedError{fmt.Errorf("cannot pause or resume while %s", t.state)}
+		}
+	case skipCmd:
+		if t.state != BREAKING {
+			return rejectedError{fmt.Errorf("cannot skip break while %s", t.state)}
+		}
+		err = t.endBreak()
+		if err == nil {
+			err = t.startPomodoro()
+		}
+	case stopCmd:
+		err = t.halt()
+	case extendCmd:
+		if cmd.n < 1 {
+			return rejectedError{fmt.Errorf("cannot extend the session by %d pomodoros", cmd.n)}
+		}
+		t.target += cmd.n
+		t.publish()
+		if t.state == RUNNING || t.state == PAUSED || t.state == BREAKING {
+			err = t.checkpoint()
+		}
+	case finishCmd:
+		err = t.finish()
+	}
+	return err
+}
+
+// expire is called when the timer of the
+// current pomodoro or break fires.
+func (t *TaskRunner) expire() error {
+	switch t.state {
+	case RUNNING:
+		t.stopped = t.clock.Now()
+		t.count++
+		err := t.record()
 		if err != nil {
 			return err
 		}
-		// All pomodoros completed
-		if t.count == t.nPomodoros {
-			break
+		// All pomodoros of the session completed
+		if t.count >= t.target {
+			return t.complete()
 		}
-		t.SetState(BREAKING)
-		t.notifier.Notify("Pomo", "It is time to take a break!")
-		// Reset the duration incase it
-		// was paused.
-		t.duration = t.origDuration
-		// User concludes the break
-		<-t.toggle
+		return t.startBreak(&Break{
+			Start:   t.clock.Now(),
+			Planned: t.breaks.Length(t.count),
+		})
+	case BREAKING:
+		if t.breaks.AutoStart {
+			t.notify(Notification{Body: "Break is over, starting the next pomodoro"})
+			err := t.endBreak()
+			if err != nil {
+				return err
+			}
+			return t.startPomodoro()
+		}
+		t.notify(Notification{
+			Body:    "Break is over!",
+			Urgency: UrgencyCritical,
+			Actions: []NotificationAction{
+				{Key: "start", Label: "Start pomodoro"},
+				{Key: "finish", Label: "Finish task"},
+			},
+		})
+	}
+	return nil
+}
+
+// startTimer runs the timer for the given
+// duration of the current pomodoro.
+func (t *TaskRunner) startTimer(duration time.Duration) {
+	t.started = t.clock.Now()
+	t.duration = duration
+	t.remaining = duration
+	t.timer.Reset(duration)
+}
 
+func (t *TaskRunner) startPomodoro() error {
+	t.pomodoro = &Pomodoro{
+		Start:  t.clock.Now(),
+		Status: PomodoroCompleted,
 	}
-	t.notifier.Notify("Pomo", "Pomo session has completed!")
-	t.SetState(COMPLETE)
+	t.startTimer(t.origDuration)
+	t.setState(RUNNING)
+	t.emit(EventPomodoroStarted)
+	return t.checkpoint()
+}
+
+func (t *TaskRunner) pausePomodoro() error {
+	t.timer.Stop()
+	// Record the remaining time of the current pomodoro
+	t.remaining = t.duration - t.clock.Since(t.started)
+	t.pause = &Pause{Start: t.clock.Now()}
+	t.setState(PAUSED)
+	t.emit(EventPaused)
+	return t.checkpoint()
+}
+
+func (t *TaskRunner) resumePomodoro() error {
+	t.pause.End = t.clock.Now()
+	t.pomodoro.Pauses = append(t.pomodoro.Pauses, t.pause)
+	t.pause = nil
+	// Resume the timer with previous
+	// remaining time
+	t.startTimer(t.remaining)
+	t.setState(RUNNING)
+	t.emit(EventResumed)
+	return t.checkpoint()
+}
+
+// startBreak begins the planned break, breaks are
+// measured in wall-clock time so a restored break
+// may already be over.
+func (t *TaskRunner) startBreak(brk *Break) error {
+	t.brk = brk
+	t.started = brk.Start
+	t.duration = brk.Planned
+	t.timer.Reset(brk.Planned - t.clock.Since(brk.Start))
+	t.setState(BREAKING)
+	t.emit(EventBreakStarted)
+	t.notify(Notification{
+		Body: fmt.Sprintf("It is time to take a %s break!", brk.Planned),
+		Actions: []NotificationAction{
+			{Key: "skip", Label: "Skip break"},
+			{Key: "finish", Label: "Finish task"},
+		},
+	})
+	return t.checkpoint()
+}
+
+func (t *TaskRunner) endBreak() error {
+	t.timer.Stop()
+	t.brk.End = t.clock.Now()
+	err := t.store.With(func(tx *sql.Tx) error {
+		return t.store.CreateBreak(tx, t.taskID, *t.brk)
+	})
+	if err != nil {
+		return err
+	}
+	t.emit(EventBreakEnded)
+	t.brk = nil
 	return nil
 }
 
-func (t *TaskRunner) Toggle() {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	if t.state == BREAKING {
-		t.toggle <- true
+// interrupt records the partial pomodoro or the break
+// in progress, a paused pomodoro is given the status.
+func (t *TaskRunner) interrupt(paused PomodoroStatus) error {
+	switch t.state {
+	case RUNNING:
+		t.timer.Stop()
+		t.pomodoro.Status = PomodoroInterrupted
+		return t.record()
+	case PAUSED:
+		t.pause.End = t.clock.Now()
+		t.pomodoro.Pauses = append(t.pomodoro.Pauses, t.pause)
+		t.pause = nil
+		t.pomodoro.Status = paused
+		return t.record()
+	case BREAKING:
+		return t.endBreak()
 	}
+	return nil
 }
 
-func (t *TaskRunner) Pause() {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	if t.state == PAUSED || t.state == RUNNING {
-		t.pause <- true
+// halt concludes a session that was stopped early,
+// recording the partial pomodoro if there is one.
+func (t *TaskRunner) halt() error {
+	err := t.interrupt(PomodoroAbandoned)
+	if err != nil {
+		return err
 	}
+	err = t.store.With(t.store.DeleteCheckpoint)
+	if err != nil {
+		return err
+	}
+	t.notify(Notification{Body: "Pomo session has been stopped!"})
+	t.setState(COMPLETE)
+	t.emit(EventSessionComplete)
+	return nil
 }
 
+// finish concludes the session before its estimate was
+// reached because the task is done, the time worked on a
+// partial pomodoro still counts towards the task.
+func (t *TaskRunner) finish() error {
+	err := t.interrupt(PomodoroInterrupted)
+	if err != nil {
+		return err
+	}
+	return t.complete()
+}
+
+func (t *TaskRunner) complete() error {
+	err := t.store.With(t.store.DeleteCheckpoint, func(tx *sql.Tx) error {
+		return t.store.SetTaskState(tx, t.taskID, TaskDone, t.clock.Now())
+	})
+	if err != nil {
+		return err
+	}
+	t.notify(Notification{Body: "Pomo session has completed!"})
+	t.setState(COMPLETE)
+	t.emit(EventSessionComplete)
+	return nil
+}
+
+// notify sends a notification about the session, the
+// actions chosen by the user are sent back as commands.
+func (t *TaskRunner) notify(notification Notification) {
+	notification.Title = "Pomo"
+	if notification.Urgency == "" {
+		notification.Urgency = UrgencyNormal
+	}
+	if len(notification.Actions) > 0 {
+		notification.OnAction = t.action
+	}
+	// notifiers may take as long as their timeout
+	// so they are not waited on by the event loop
+	t.notifying.Add(1)
+	go func() {
+		defer t.notifying.Done()
+		err := t.notifier.Notify(notification)
+		if err != nil {
+			log.Printf("failed to send notification: %s", err)
+		}
+	}()
+}
+
+// closeNotifier releases the notifier once
+// every notification of the session was sent.
+func (t *TaskRunner) closeNotifier() {
+	closer, ok := t.notifier.(io.Closer)
+	if !ok {
+		return
+	}
+	err := closer.Close()
+	if err != nil {
+		log.Printf("failed to close notifier: %s", err)
+	}
+}
+
+// action runs the notification action with the given key
+func (t *TaskRunner) action(key string) {
+	var err error
+	switch key {
+	case "start", "skip":
+		err = t.Toggle()
+	case "finish":
+		err = t.Finish()
+	default:
+		err = fmt.Errorf("unknown action %q", key)
+	}
+	if err != nil {
+		log.Printf("notification action %s failed: %s", key, err)
+	}
+}
+
+// record stores the current pomodoro once it has ended
+func (t *TaskRunner) record() error {
+	t.pomodoro.End = t.clock.Now()
+	err := t.store.With(func(tx *sql.Tx) error {
+		pomodoroID, err := t.store.CreatePomodoro(tx, t.taskID, *t.pomodoro)
+		t.pomodoro.ID = pomodoroID
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	t.publish()
+	t.emit(EventPomodoroEnded)
+	t.pomodoro = nil
+	return nil
+}
+
+// checkpoint saves the progress of the session so
+// it can be restored if the process dies.
+func (t *TaskRunner) checkpoint() error {
+	checkpoint := Checkpoint{
+		TaskID:    t.taskID,
+		State:     t.state,
+		Count:     t.count,
+		Target:    t.target,
+		Duration:  t.duration,
+		Remaining: t.remaining,
+		Updated:   t.clock.Now(),
+		PID:       os.Getpid(),
+	}
+	t.checkpointed = t.clock.Now()
+	switch t.state {
+	case RUNNING, PAUSED:
+		checkpoint.Started = t.pomodoro.Start
+		checkpoint.Pauses = t.pomodoro.Pauses
+		if t.pause != nil {
+			// While paused the checkpoint is dated from the
+			// start of the pause so it is not lost on restore.
+			checkpoint.Updated = t.pause.Start
+		}
+	case BREAKING:
+		checkpoint.Started = t.brk.Start
+	}
+	return t.store.With(func(tx *sql.Tx) error {
+		return t.store.SaveCheckpoint(tx, checkpoint)
+	})
+}
+
+// send delivers a command to the event loop
+// and waits for it to be applied.
+func (t *TaskRunner) send(kind commandKind) error {
+	return t.sendCommand(runnerCommand{kind: kind})
+}
+
+func (t *TaskRunner) sendCommand(cmd runnerCommand) error {
+	cmd.reply = make(chan error, 1)
+	select {
+	case t.commands <- cmd:
+		return <-cmd.reply
+	case <-t.done:
+		return ErrSessionConcluded
+	}
+}
+
+// Toggle concludes the current break and
+// begins the next pomodoro.
+func (t *TaskRunner) Toggle() error { return t.send(skipCmd) }
+
+// TogglePause pauses a running pomodoro
+// or resumes a paused one.
+func (t *TaskRunner) TogglePause() error { return t.send(togglePauseCmd) }
+
+// Pause suspends a running pomodoro.
+func (t *TaskRunner) Pause() error { return t.send(pauseCmd) }
+
+// Resume continues a paused pomodoro.
+func (t *TaskRunner) Resume() error { return t.send(resumeCmd) }
+
+// Stop ends the session before all
+// pomodoros have been completed.
+func (t *TaskRunner) Stop() error { return t.send(stopCmd) }
+
+// Extend raises the number of pomodoros in the
+// session past the estimate of the task.
+func (t *TaskRunner) Extend(n int) error {
+	return t.sendCommand(runnerCommand{kind: extendCmd, n: n})
+}
+
+// Finish ends the session and marks the task as done
+// even if fewer pomodoros than estimated were completed.
+func (t *TaskRunner) Finish() error { return t.send(finishCmd) }
+
+// Status returns the state of the session
+// as of the last change made by the event loop.
 func (t *TaskRunner) Status() *Status {
-	return &Status{
+	t.mu.RLock()
+	snap := t.snapshot
+	t.mu.RUnlock()
+	status := &Status{
 		TaskID:        t.taskID,
 		TaskMessage:   t.taskMessage,
-		State:         t.state,
-		Count:         t.count,
-		NPomodoros:    t.nPomodoros,
-		Remaining:     t.TimeRemaining(),
-		Pauseduration: t.TimePauseDuration(),
+		State:         snap.state,
+		Count:         snap.count,
+		NPomodoros:    snap.target,
+		Estimate:      t.nPomodoros,
+		Pauseduration: t.clock.Since(snap.stopped).Truncate(time.Second),
+	}
+	switch snap.state {
+	case PAUSED:
+		status.Remaining = snap.remaining.Truncate(time.Second)
+	case BREAKING:
+		status.BreakDuration = snap.duration
+		status.Remaining = (snap.duration - t.clock.Since(snap.started)).Truncate(time.Second)
+		if status.Remaining < 0 {
+			// break has run over
+			status.Remaining = 0
+		}
+	default:
+		status.Remaining = (snap.duration - t.clock.Since(snap.started)).Truncate(time.Second)
 	}
+	return status
 }
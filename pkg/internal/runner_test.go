@@ -1,37 +1,418 @@
 package pomo
 
 import (
-	"fmt"
-	"io/ioutil"
-	"path"
+	"database/sql"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
-func TestTaskRunner(t *testing.T) {
-	baseDir, _ := ioutil.TempDir("/tmp", "")
-	store, err := NewStore(path.Join(baseDir, "pomo.db"))
+func createTestTask(t *testing.T, store *Store, task *Task) *Task {
+	t.Helper()
+	err := store.With(func(tx *sql.Tx) error {
+		taskID, err := store.CreateTask(tx, *task)
+		task.ID = taskID
+		return err
+	})
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	err = InitDB(store)
+	return task
+}
+
+func initTestRunner(t *testing.T, task *Task) (*TaskRunner, *Store, *FakeClock) {
+	t.Helper()
+	store := initTestStore(t)
+	createTestTask(t, store, task)
+	clock := NewFakeClock(time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC))
+	runner, err := NewMockedTaskRunner(task, store, NoopNotifier{}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runner, store, clock
+}
+
+func readTestTask(t *testing.T, store *Store, taskID int) *Task {
+	t.Helper()
+	var task *Task
+	err := store.With(func(tx *sql.Tx) error {
+		read, err := store.ReadTask(tx, taskID)
+		task = read
+		return err
+	})
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	runner, err := NewMockedTaskRunner(&Task{
-		Duration:   time.Second * 2,
+	return task
+}
+
+func TestTaskRunner(t *testing.T) {
+	runner, store, clock := initTestRunner(t, &Task{
+		Duration:   25 * time.Minute,
 		NPomodoros: 2,
-		Message:    fmt.Sprint("Test Task"),
-	}, store, NoopNotifier{})
+		Message:    "Test Task",
+	})
+	defer store.Close()
+	start := clock.Now()
+
+	runner.Start()
+	waitState(t, runner, RUNNING)
+
+	clock.Advance(10 * time.Minute)
+	if remaining := runner.Status().Remaining; remaining != 15*time.Minute {
+		t.Fatalf("expected 15m remaining, got %s", remaining)
+	}
+	if err := runner.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, runner, PAUSED)
+	clock.Advance(5 * time.Minute)
+	if err := runner.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, runner, RUNNING)
+	if remaining := runner.Status().Remaining; remaining != 15*time.Minute {
+		t.Fatalf("expected 15m remaining after resuming, got %s", remaining)
+	}
+
+	clock.Advance(15 * time.Minute)
+	waitState(t, runner, BREAKING)
+	status := runner.Status()
+	if status.Count != 1 || status.BreakDuration != 5*time.Minute {
+		t.Fatalf("unexpected status while breaking %v", status)
+	}
+	clock.Advance(2 * time.Minute)
+	if err := runner.Toggle(); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, runner, RUNNING)
+
+	clock.Advance(25 * time.Minute)
+	waitState(t, runner, COMPLETE)
+	<-runner.Done()
+	if err := runner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	task := readTestTask(t, store, runner.taskID)
+	if len(task.Pomodoros) != 2 {
+		t.Fatalf("expected 2 pomodoros, got %d", len(task.Pomodoros))
+	}
+	if task.State != TaskDone {
+		t.Fatalf("expected task to be done, got %s", task.State)
+	}
+	first := task.Pomodoros[0]
+	if !first.Start.Equal(start) || !first.End.Equal(start.Add(30*time.Minute)) {
+		t.Fatalf("unexpected first pomodoro %s - %s", first.Start, first.End)
+	}
+	if len(first.Pauses) != 1 || !first.Pauses[0].Start.Equal(start.Add(10*time.Minute)) {
+		t.Fatalf("unexpected pauses %v", first.Pauses)
+	}
+	if first.Worked() != 25*time.Minute || first.Status != PomodoroCompleted {
+		t.Fatalf("expected 25m completed pomodoro, got %s %s", first.Worked(), first.Status)
+	}
+	second := task.Pomodoros[1]
+	if !second.Start.Equal(start.Add(32*time.Minute)) || second.Duration() != 25*time.Minute {
+		t.Fatalf("unexpected second pomodoro %s - %s", second.Start, second.End)
+	}
+	if len(task.Breaks) != 1 || task.Breaks[0].Duration() != 2*time.Minute || task.Breaks[0].Planned != 5*time.Minute {
+		t.Fatalf("unexpected breaks %v", task.Breaks)
+	}
+}
+
+func TestTaskRunnerStopPaused(t *testing.T) {
+	runner, store, clock := initTestRunner(t, &Task{
+		Duration:   25 * time.Minute,
+		NPomodoros: 1,
+	})
+	defer store.Close()
+
+	runner.Start()
+	waitState(t, runner, RUNNING)
+	clock.Advance(time.Minute)
+	if err := runner.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, runner, PAUSED)
+	clock.Advance(time.Minute)
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	<-runner.Done()
+
+	task := readTestTask(t, store, runner.taskID)
+	if len(task.Pomodoros) != 1 || task.Pomodoros[0].Status != PomodoroAbandoned {
+		t.Fatalf("expected a single abandoned pomodoro, got %v", task.Pomodoros)
+	}
+	if task.Pomodoros[0].Worked() != time.Minute {
+		t.Fatalf("expected 1m worked, got %s", task.Pomodoros[0].Worked())
+	}
+	if task.State != TaskActive {
+		t.Fatalf("expected stopped task to remain active, got %s", task.State)
+	}
+}
+
+func TestTaskRunnerExtendFinish(t *testing.T) {
+	runner, store, clock := initTestRunner(t, &Task{
+		Duration:   25 * time.Minute,
+		NPomodoros: 1,
+	})
+	defer store.Close()
+
+	runner.Start()
+	waitState(t, runner, RUNNING)
+	if err := runner.Extend(0); err == nil {
+		t.Fatal("expected extending by zero pomodoros to fail")
+	}
+	if err := runner.Extend(2); err != nil {
+		t.Fatal(err)
+	}
+	if status := runner.Status(); status.NPomodoros != 3 || status.Estimate != 1 {
+		t.Fatalf("expected 3 pomodoros estimated at 1, got %d and %d", status.NPomodoros, status.Estimate)
+	}
+	// the estimate is reached but the session continues
+	clock.Advance(25 * time.Minute)
+	waitState(t, runner, BREAKING)
+	if err := runner.Toggle(); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, runner, RUNNING)
+	clock.Advance(10 * time.Minute)
+	if err := runner.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	<-runner.Done()
+
+	task := readTestTask(t, store, runner.taskID)
+	if task.State != TaskDone || task.Finished == nil || !task.Finished.Equal(clock.Now()) {
+		t.Fatalf("expected finished task to be done at %s, got %s at %v", clock.Now(), task.State, task.Finished)
+	}
+	if task.NPomodoros != 1 {
+		t.Fatalf("expected the estimate to be kept, got %d", task.NPomodoros)
+	}
+	if len(task.Pomodoros) != 2 || task.Pomodoros[1].Status != PomodoroInterrupted {
+		t.Fatalf("expected a completed and an interrupted pomodoro, got %v", task.Pomodoros)
+	}
+	if actual := task.Actual(); actual != 1.4 {
+		t.Fatalf("expected 1.4 actual pomodoros, got %f", actual)
+	}
+}
+
+func TestTaskRunnerRestore(t *testing.T) {
+	runner, store, clock := initTestRunner(t, &Task{
+		Duration:   25 * time.Minute,
+		NPomodoros: 1,
+	})
+	defer store.Close()
+	now := clock.Now()
+
+	// the process died 5 minutes ago with 10 minutes remaining
+	err := runner.Restore(&Checkpoint{
+		TaskID:    runner.taskID,
+		State:     RUNNING,
+		Started:   now.Add(-20 * time.Minute),
+		Remaining: 10 * time.Minute,
+		Updated:   now.Add(-5 * time.Minute),
+	})
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	runner.Start()
+	waitState(t, runner, RUNNING)
+	if remaining := runner.Status().Remaining; remaining != 10*time.Minute {
+		t.Fatalf("expected 10m remaining, got %s", remaining)
+	}
+	clock.Advance(10 * time.Minute)
+	waitState(t, runner, COMPLETE)
+	<-runner.Done()
+
+	task := readTestTask(t, store, runner.taskID)
+	if len(task.Pomodoros) != 1 {
+		t.Fatalf("expected 1 pomodoro, got %d", len(task.Pomodoros))
 	}
+	pomodoro := task.Pomodoros[0]
+	if pomodoro.Worked() != 25*time.Minute || pomodoro.Paused() != 5*time.Minute {
+		t.Fatalf("expected 25m worked and 5m paused, got %s and %s", pomodoro.Worked(), pomodoro.Paused())
+	}
+}
+
+func TestTaskRunnerSubscribe(t *testing.T) {
+	runner, store, clock := initTestRunner(t, &Task{
+		Duration:   25 * time.Minute,
+		NPomodoros: 2,
+	})
+	defer store.Close()
 
+	events, cancel := runner.Subscribe()
+	defer cancel()
 	runner.Start()
+	waitState(t, runner, RUNNING)
+	if err := runner.TogglePause(); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.TogglePause(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(25 * time.Minute)
+	waitState(t, runner, BREAKING)
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
 
-	runner.Toggle()
-	runner.Toggle()
+	expected := []EventType{
+		EventPomodoroStarted,
+		EventPaused,
+		EventResumed,
+		EventPomodoroEnded,
+		EventBreakStarted,
+		EventBreakEnded,
+		EventSessionComplete,
+	}
+	var received []EventType
+	for event := range events {
+		received = append(received, event.Type)
+	}
+	if len(received) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, received)
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("expected events %v, got %v", expected, received)
+		}
+	}
+	if err := runner.Pause(); err != ErrSessionConcluded {
+		t.Fatalf("expected %s, got %v", ErrSessionConcluded, err)
+	}
+}
+
+func TestTaskRunnerConcurrentCommands(t *testing.T) {
+	runner, store, _ := initTestRunner(t, &Task{
+		Duration:   25 * time.Minute,
+		NPomodoros: 1,
+	})
+	defer store.Close()
 
-	runner.Toggle()
-	runner.Toggle()
+	runner.Start()
+	waitState(t, runner, RUNNING)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				runner.TogglePause()
+				runner.Status()
+			}
+		}()
+	}
+	wg.Wait()
+	// an even number of toggles leaves the pomodoro running
+	waitState(t, runner, RUNNING)
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	<-runner.Done()
+
+	task := readTestTask(t, store, runner.taskID)
+	if len(task.Pomodoros) != 1 || len(task.Pomodoros[0].Pauses) != 200 {
+		t.Fatalf("expected one pomodoro with 200 pauses, got %v", task.Pomodoros)
+	}
+}
+
+func readTestCheckpoint(t *testing.T, store *Store) *Checkpoint {
+	t.Helper()
+	var checkpoint *Checkpoint
+	err := store.With(func(tx *sql.Tx) error {
+		read, err := store.ReadCheckpoint(tx)
+		checkpoint = read
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return checkpoint
+}
+
+func TestTaskRunnerCheckpoint(t *testing.T) {
+	runner, store, clock := initTestRunner(t, &Task{
+		Duration:   25 * time.Minute,
+		NPomodoros: 1,
+	})
+	defer store.Close()
+	other := createTestTask(t, store, &Task{Duration: 25 * time.Minute, NPomodoros: 1})
+	now := clock.Now()
+	running := Checkpoint{
+		TaskID:  other.ID,
+		State:   RUNNING,
+		Started: now.Add(-10 * time.Minute),
+		Updated: now,
+		// the parent process is alive
+		PID: os.Getppid(),
+	}
+	err := store.With(func(tx *sql.Tx) error {
+		return store.SaveCheckpoint(tx, running)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.Start()
+	<-runner.Done()
+	if err := runner.Err(); err == nil || !strings.Contains(err.Error(), "is running with pid") {
+		t.Fatalf("expected the session to refuse to start, got %v", err)
+	}
+	if checkpoint := readTestCheckpoint(t, store); checkpoint == nil || checkpoint.TaskID != other.ID {
+		t.Fatalf("expected the running session to keep its checkpoint, got %v", checkpoint)
+	}
+
+	// once its process exited the session is abandoned
+	running.PID = 0
+	err = store.With(func(tx *sql.Tx) error {
+		return store.SaveCheckpoint(tx, running)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner, err = NewMockedTaskRunner(readTestTask(t, store, runner.taskID), store, NoopNotifier{}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.Start()
+	waitState(t, runner, RUNNING)
+	if task := readTestTask(t, store, other.ID); len(task.Pomodoros) != 1 || task.Pomodoros[0].Status != PomodoroAbandoned {
+		t.Fatalf("expected an abandoned pomodoro, got %v", task.Pomodoros)
+	}
+	checkpoint := readTestCheckpoint(t, store)
+	if checkpoint.TaskID != runner.taskID || checkpoint.PID != os.Getpid() || !checkpoint.Updated.Equal(now) {
+		t.Fatalf("unexpected checkpoint %v", checkpoint)
+	}
+
+	// a running pomodoro is only saved every checkpointInterval
+	clock.Advance(checkpointInterval / 2)
+	for i := 0; i < 10; i++ {
+		// commands are handled after the pending tick
+		runner.Extend(0)
+	}
+	if checkpoint := readTestCheckpoint(t, store); !checkpoint.Updated.Equal(now) {
+		t.Fatalf("expected no checkpoint before the interval, got %v", checkpoint)
+	}
+	clock.Advance(checkpointInterval / 2)
+	for i := 0; i < 100; i++ {
+		if checkpoint = readTestCheckpoint(t, store); checkpoint.Updated.Equal(now.Add(checkpointInterval)) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !checkpoint.Updated.Equal(now.Add(checkpointInterval)) ||
+		checkpoint.Remaining != 25*time.Minute-checkpointInterval {
+		t.Fatalf("expected a checkpoint after the interval, got %v", checkpoint)
+	}
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	<-runner.Done()
+	if checkpoint := readTestCheckpoint(t, store); checkpoint != nil {
+		t.Fatalf("expected the checkpoint to be removed, got %v", checkpoint)
+	}
 }
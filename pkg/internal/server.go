@@ -1,62 +1,260 @@
 package pomo
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"sync"
 	"time"
 )
 
+// ProtocolVersion is incremented whenever the
+// socket protocol changes incompatibly.
+const ProtocolVersion = 1
+
+// Command is an action requested by a Client
+type Command string
+
+const (
+	StatusCommand      Command = "status"
+	PauseCommand       Command = "pause"
+	ResumeCommand      Command = "resume"
+	TogglePauseCommand Command = "toggle"
+	StopCommand        Command = "stop"
+	SkipCommand        Command = "skip"
+	// ExtendCommand adds Count pomodoros to the session
+	ExtendCommand Command = "extend"
+	// FinishCommand ends the session and marks the task as done
+	FinishCommand Command = "finish"
+	// SubscribeCommand keeps the connection open and
+	// streams each Event as a single JSON encoded line.
+	SubscribeCommand Command = "subscribe"
+)
+
+// Request is sent from a Client to the Server
+// as a single JSON encoded line.
+type Request struct {
+	Version int     `json:"version"`
+	Command Command `json:"command"`
+	// Ticks requests a tick event every second
+	// in addition to the events of a subscription.
+	Ticks bool `json:"ticks,omitempty"`
+	// Count is the number of pomodoros to extend
+	// the session by, it defaults to one.
+	Count int `json:"count,omitempty"`
+}
+
+// Response is returned for each Request and contains
+// the status after the command was applied.
+type Response struct {
+	Version int     `json:"version"`
+	Status  *Status `json:"status,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
 // Server listens on a Unix domain socket
 // for Pomo status requests
 type Server struct {
-	listener          net.Listener
-	runner            *TaskRunner
-	running           bool
+	listener net.Listener
+	runner   *TaskRunner
+	stop     chan struct{}
+	// held for reading while a request is applied
+	// so Stop can wait for replies to be written
+	inflight          sync.RWMutex
+	subscriptions     sync.WaitGroup
 	publish           bool
 	publishJson       bool
 	publishSocketPath string
 }
 
 func (s *Server) listen() {
-	for s.running {
+	for {
+		// Accept fails once the listener is closed by Stop
 		conn, err := s.listener.Accept()
 		if err != nil {
-			break
+			return
 		}
-		buf := make([]byte, 512)
-		// Ignore any content
-		conn.Read(buf)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return
+	}
+	// Clients that predate the protocol send arbitrary
+	// content and expect the status in return.
+	if !bytes.HasPrefix(bytes.TrimSpace(buf[0:n]), []byte("{")) {
 		raw, _ := json.Marshal(s.runner.Status())
 		conn.Write(raw)
-		conn.Close()
+		return
+	}
+	decoder := json.NewDecoder(io.MultiReader(bytes.NewReader(buf[0:n]), conn))
+	encoder := json.NewEncoder(conn)
+	for {
+		request := Request{}
+		err := decoder.Decode(&request)
+		if err != nil {
+			if err != io.EOF {
+				encoder.Encode(Response{
+					Version: ProtocolVersion,
+					Error:   fmt.Sprintf("invalid request: %s", err),
+				})
+			}
+			return
+		}
+		if request.Command == SubscribeCommand {
+			s.subscribe(encoder, request)
+			return
+		}
+		if !s.reply(encoder, request) {
+			return
+		}
+	}
+}
+
+// reply applies a request and writes the response,
+// returning false if the connection should be closed.
+func (s *Server) reply(encoder *json.Encoder, request Request) bool {
+	s.inflight.RLock()
+	defer s.inflight.RUnlock()
+	select {
+	case <-s.stop:
+		return false
+	default:
+	}
+	return encoder.Encode(s.apply(request)) == nil
+}
+
+// subscribe acknowledges a subscription with the current status
+// and then writes each event until the session concludes, the
+// client goes away or the server is stopped.
+func (s *Server) subscribe(encoder *json.Encoder, request Request) {
+	s.inflight.RLock()
+	select {
+	case <-s.stop:
+		s.inflight.RUnlock()
+		return
+	default:
+	}
+	s.subscriptions.Add(1)
+	s.inflight.RUnlock()
+	defer s.subscriptions.Done()
+
+	response := Response{Version: ProtocolVersion}
+	if request.Version > ProtocolVersion {
+		response.Error = fmt.Sprintf("unsupported protocol version %d", request.Version)
+		encoder.Encode(response)
+		return
+	}
+	events, cancel := s.runner.Subscribe()
+	defer cancel()
+	response.Status = s.runner.Status()
+	if encoder.Encode(response) != nil {
+		return
+	}
+	var ticks <-chan time.Time
+	if request.Ticks {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok || encoder.Encode(event) != nil {
+				return
+			}
+		case now := <-ticks:
+			event := Event{Type: EventTick, Time: now, Status: *s.runner.Status()}
+			if encoder.Encode(event) != nil {
+				return
+			}
+		case <-s.stop:
+			// deliver events emitted before the session concluded
+			for {
+				select {
+				case event, ok := <-events:
+					if !ok || encoder.Encode(event) != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
 	}
 }
 
+// apply executes a single request against the runner
+func (s *Server) apply(request Request) Response {
+	response := Response{Version: ProtocolVersion}
+	if request.Version > ProtocolVersion {
+		response.Error = fmt.Sprintf("unsupported protocol version %d", request.Version)
+		return response
+	}
+	var err error
+	switch request.Command {
+	case StatusCommand, "":
+	case PauseCommand:
+		err = s.runner.Pause()
+	case ResumeCommand:
+		err = s.runner.Resume()
+	case TogglePauseCommand:
+		err = s.runner.TogglePause()
+	case StopCommand:
+		err = s.runner.Stop()
+	case SkipCommand:
+		err = s.runner.Toggle()
+	case ExtendCommand:
+		count := request.Count
+		if count == 0 {
+			count = 1
+		}
+		err = s.runner.Extend(count)
+	case FinishCommand:
+		err = s.runner.Finish()
+	default:
+		err = fmt.Errorf("unknown command %q", request.Command)
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	response.Status = s.runner.Status()
+	return response
+}
+
 func (s *Server) push() {
 	ticker := time.NewTicker(1 * time.Second)
-	for s.running {
+	defer ticker.Stop()
+	for {
 		conn, err := net.Dial("unix", s.publishSocketPath)
-		if err != nil {
-			<-ticker.C
-			continue
+		if err == nil {
+			status := s.runner.Status()
+			if s.publishJson {
+				raw, _ := json.Marshal(status)
+				json.NewEncoder(conn).Encode(raw)
+			} else {
+				conn.Write([]byte(FormatStatus(*status) + "\n"))
+			}
+			conn.Close()
 		}
-		status := s.runner.Status()
-		if s.publishJson {
-			raw, _ := json.Marshal(status)
-			json.NewEncoder(conn).Encode(raw)
-		} else {
-			conn.Write([]byte(FormatStatus(*status) + "\n"))
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
 		}
-		conn.Close()
-		<-ticker.C
 	}
 }
 
 func (s *Server) Start() {
-	s.running = true
 	if s.publish {
 		go s.push()
 	}
@@ -65,10 +263,19 @@ func (s *Server) Start() {
 }
 
 func (s *Server) Stop() {
-	s.running = false
+	select {
+	case <-s.stop:
+		return
+	default:
+		close(s.stop)
+	}
 	if s.listener != nil {
 		s.listener.Close()
 	}
+	// wait for requests that are being applied
+	s.inflight.Lock()
+	s.inflight.Unlock()
+	s.subscriptions.Wait()
 }
 
 func NewServer(runner *TaskRunner, config *Config) (*Server, error) {
@@ -91,6 +298,7 @@ func NewServer(runner *TaskRunner, config *Config) (*Server, error) {
 	server := &Server{
 		listener:          listener,
 		runner:            runner,
+		stop:              make(chan struct{}),
 		publish:           config.Publish,
 		publishJson:       config.PublishJson,
 		publishSocketPath: config.PublishSocketPath,
@@ -103,22 +311,87 @@ func NewServer(runner *TaskRunner, config *Config) (*Server, error) {
 // pomo server to check the status of
 // any currently running task session.
 type Client struct {
-	conn net.Conn
+	conn    net.Conn
+	decoder *json.Decoder
 }
 
-func (c Client) read(statusCh chan *Status) {
-	buf := make([]byte, 512)
-	n, _ := c.conn.Read(buf)
-	status := &Status{}
-	json.Unmarshal(buf[0:n], status)
-	statusCh <- status
+// Do sends a single command to the server and
+// returns the resulting status.
+func (c Client) Do(command Command) (*Status, error) {
+	return c.send(Request{Version: ProtocolVersion, Command: command})
+}
+
+func (c Client) send(request Request) (*Status, error) {
+	raw, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.conn.Write(append(raw, '\n'))
+	if err != nil {
+		return nil, err
+	}
+	response := Response{}
+	err = c.decoder.Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return response.Status, errors.New(response.Error)
+	}
+	return response.Status, nil
 }
 
-func (c Client) Status() (*Status, error) {
-	statusCh := make(chan *Status)
-	c.conn.Write([]byte("status"))
-	go c.read(statusCh)
-	return <-statusCh, nil
+func (c Client) Status() (*Status, error) { return c.Do(StatusCommand) }
+
+func (c Client) Pause() (*Status, error) { return c.Do(PauseCommand) }
+
+func (c Client) Resume() (*Status, error) { return c.Do(ResumeCommand) }
+
+func (c Client) TogglePause() (*Status, error) { return c.Do(TogglePauseCommand) }
+
+func (c Client) Stop() (*Status, error) { return c.Do(StopCommand) }
+
+func (c Client) Skip() (*Status, error) { return c.Do(SkipCommand) }
+
+// Extend adds pomodoros to the session past the estimate of the task
+func (c Client) Extend(count int) (*Status, error) {
+	return c.send(Request{Version: ProtocolVersion, Command: ExtendCommand, Count: count})
+}
+
+func (c Client) Finish() (*Status, error) { return c.Do(FinishCommand) }
+
+// Subscribe streams events from the server until the session
+// concludes or the client is closed. The returned status is
+// the state of the session when the subscription began.
+func (c Client) Subscribe(ticks bool) (*Status, <-chan Event, error) {
+	raw, err := json.Marshal(Request{Version: ProtocolVersion, Command: SubscribeCommand, Ticks: ticks})
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = c.conn.Write(append(raw, '\n'))
+	if err != nil {
+		return nil, nil, err
+	}
+	response := Response{}
+	err = c.decoder.Decode(&response)
+	if err != nil {
+		return nil, nil, err
+	}
+	if response.Error != "" {
+		return nil, nil, errors.New(response.Error)
+	}
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		for {
+			event := Event{}
+			if c.decoder.Decode(&event) != nil {
+				return
+			}
+			events <- event
+		}
+	}()
+	return response.Status, events, nil
 }
 
 func (c Client) Close() error { return c.conn.Close() }
@@ -128,5 +401,5 @@ func NewClient(path string) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Client{conn: conn}, nil
+	return &Client{conn: conn, decoder: json.NewDecoder(conn)}, nil
 }
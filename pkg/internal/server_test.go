@@ -0,0 +1,173 @@
+package pomo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path"
+	"testing"
+	"time"
+)
+
+func waitState(t *testing.T, runner *TaskRunner, state State) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if runner.Status().State == state {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected state %s, got %s", state, runner.Status().State)
+}
+
+func TestServerProtocol(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	runner, err := NewMockedTaskRunner(createTestTask(t, store, &Task{
+		Duration:   time.Minute,
+		NPomodoros: 2,
+		Message:    "Test Task",
+	}), store, NoopNotifier{}, SystemClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseDir, _ := ioutil.TempDir("/tmp", "")
+	socketPath := path.Join(baseDir, "pomo.sock")
+	server, err := NewServer(runner, &Config{SocketPath: socketPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop()
+	runner.Start()
+	waitState(t, runner, RUNNING)
+
+	client, err := NewClient(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.Resume(); err == nil {
+		t.Fatal("expected resume of a running pomodoro to fail")
+	}
+	if _, err := client.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, runner, PAUSED)
+	if _, err := client.TogglePause(); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, runner, RUNNING)
+	if _, err := client.Skip(); err == nil {
+		t.Fatal("expected skip outside of a break to fail")
+	}
+	if _, err := client.Do(Command("bogus")); err == nil {
+		t.Fatal("expected unknown command to fail")
+	}
+
+	// legacy clients receive the bare status
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("\n"))
+	status := &Status{}
+	err = json.NewDecoder(conn).Decode(status)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.TaskMessage != "Test Task" {
+		t.Fatalf("unexpected legacy status %v", status)
+	}
+
+	if _, err := client.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	<-runner.Done()
+	err = store.With(func(tx *sql.Tx) error {
+		pomodoros, err := store.ReadPomodoros(tx, runner.taskID)
+		if err != nil {
+			return err
+		}
+		if len(pomodoros) != 1 || pomodoros[0].Status != PomodoroInterrupted {
+			t.Fatalf("expected a single interrupted pomodoro, got %v", pomodoros)
+		}
+		if len(pomodoros[0].Pauses) != 1 {
+			t.Fatalf("expected the pause command to be recorded, got %d pauses", len(pomodoros[0].Pauses))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerSubscribe(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	runner, err := NewMockedTaskRunner(createTestTask(t, store, &Task{
+		Duration:   time.Minute,
+		NPomodoros: 1,
+	}), store, NoopNotifier{}, SystemClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseDir, _ := ioutil.TempDir("/tmp", "")
+	socketPath := path.Join(baseDir, "pomo.sock")
+	server, err := NewServer(runner, &Config{SocketPath: socketPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	defer server.Stop()
+	runner.Start()
+	waitState(t, runner, RUNNING)
+
+	subscriber, err := NewClient(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Close()
+	status, events, err := subscriber.Subscribe(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != RUNNING {
+		t.Fatalf("expected subscription to begin while running, got %s", status.State)
+	}
+	if event := <-events; event.Type != EventTick || event.Status.State != RUNNING {
+		t.Fatalf("expected a tick, got %v", event)
+	}
+
+	client, err := NewClient(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	<-runner.Done()
+
+	var received []EventType
+	for event := range events {
+		if event.Type != EventTick {
+			received = append(received, event.Type)
+		}
+	}
+	expected := []EventType{EventPaused, EventPomodoroEnded, EventSessionComplete}
+	if len(received) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, received)
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("expected events %v, got %v", expected, received)
+		}
+	}
+}
@@ -2,7 +2,8 @@ package pomo
 
 import (
 	"database/sql"
-	"strings"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -17,7 +18,25 @@ type Store struct {
 	db *sql.DB
 }
 
+// NewStore opens the database at path and verifies
+// that its schema matches this version of pomo.
 func NewStore(path string) (*Store, error) {
+	store, err := OpenStore(path)
+	if err != nil {
+		return nil, err
+	}
+	err = store.checkVersion()
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// OpenStore opens the database at path without checking
+// its schema version, it should only be used to
+// initialize or migrate the database.
+func OpenStore(path string) (*Store, error) {
 	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, err
@@ -43,15 +62,34 @@ func (s Store) With(fns ...func(tx *sql.Tx) error) error {
 	return tx.Commit()
 }
 
+// CreateTask stores a new task, sub-tasks
+// inherit the project of their parent by default.
 func (s Store) CreateTask(tx *sql.Tx, task Task) (int, error) {
 	var taskID int
-	_, err := tx.Exec(
-		"INSERT INTO task (message,pomodoros,duration,tags) VALUES ($1,$2,$3,$4)",
-		task.Message, task.NPomodoros, task.Duration.String(), strings.Join(task.Tags, ","))
+	if task.State == "" {
+		task.State = TaskTodo
+	}
+	var parentID sql.NullInt64
+	if task.ParentID != 0 {
+		parent, err := s.ReadTask(tx, task.ParentID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return -1, fmt.Errorf("parent task %d does not exist", task.ParentID)
+			}
+			return -1, err
+		}
+		parentID = sql.NullInt64{Int64: int64(parent.ID), Valid: true}
+		if task.Project == "" {
+			task.Project = parent.Project
+		}
+	}
+	projectID, err := ensureProject(tx, task.Project)
 	if err != nil {
 		return -1, err
 	}
-	err = tx.QueryRow("SELECT last_insert_rowid() FROM task").Scan(&taskID)
+	_, err = tx.Exec(
+		"INSERT INTO task (message,pomodoros,duration,state,project_id,parent_id,finished) VALUES ($1,$2,$3,$4,$5,$6,$7)",
+		task.Message, task.NPomodoros, task.Duration.String(), task.State, projectID, parentID, task.Finished)
 	if err != nil {
 		return -1, err
 	}
@@ -59,48 +97,90 @@ func (s Store) CreateTask(tx *sql.Tx, task Task) (int, error) {
 	if err != nil {
 		return -1, err
 	}
+	err = setTaskTags(tx, taskID, task.Tags)
+	if err != nil {
+		return -1, err
+	}
 	return taskID, nil
 }
 
-func (s Store) ReadTasks(tx *sql.Tx) ([]*Task, error) {
-	rows, err := tx.Query(`SELECT rowid,message,pomodoros,duration,tags FROM task`)
+// UpdateTask replaces the message, tags, number of pomodoros
+// and duration of a stored task, its history is unchanged.
+func (s Store) UpdateTask(tx *sql.Tx, task Task) error {
+	result, err := tx.Exec(
+		"UPDATE task SET message = $1, pomodoros = $2, duration = $3 WHERE rowid = $4",
+		task.Message, task.NPomodoros, task.Duration.String(), task.ID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	tasks := []*Task{}
-	for rows.Next() {
-		var (
-			tags        string
-			strDuration string
-		)
-		task := &Task{Pomodoros: []*Pomodoro{}}
-		err = rows.Scan(&task.ID, &task.Message, &task.NPomodoros, &strDuration, &tags)
-		if err != nil {
-			return nil, err
-		}
-		duration, _ := time.ParseDuration(strDuration)
-		task.Duration = duration
-		if tags != "" {
-			task.Tags = strings.Split(tags, ",")
-		}
-		pomodoros, err := s.ReadPomodoros(tx, task.ID)
-		if err != nil {
-			return nil, err
-		}
-		for _, pomodoro := range pomodoros {
-			task.Pomodoros = append(task.Pomodoros, pomodoro)
-		}
-		tasks = append(tasks, task)
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
 	}
-	return tasks, nil
+	_, err = tx.Exec("DELETE FROM task_tag WHERE task_id = $1", task.ID)
+	if err != nil {
+		return err
+	}
+	return setTaskTags(tx, task.ID, task.Tags)
+}
+
+// SetTaskState moves a task to another state of its
+// lifecycle, at is when a task was finished if it is done.
+func (s Store) SetTaskState(tx *sql.Tx, taskID int, state TaskState, at time.Time) error {
+	// finished is kept when a done task is archived
+	// and cleared when it is started again
+	result, err := tx.Exec(`
+	UPDATE task SET state = $1, finished = CASE $1
+	    WHEN 'done' THEN COALESCE(finished, $2)
+	    WHEN 'archived' THEN finished
+	    END
+	WHERE rowid = $3`, state, at, taskID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s Store) ReadTasks(tx *sql.Tx) ([]*Task, error) {
+	return s.QueryTasks(tx, TaskQuery{})
+}
+
+// ReadTasksByTag returns all tasks labeled with the given tag.
+func (s Store) ReadTasksByTag(tx *sql.Tx, tag string) ([]*Task, error) {
+	return s.QueryTasks(tx, TaskQuery{Tags: []string{tag}})
 }
 
 func (s Store) DeleteTask(tx *sql.Tx, taskID int) error {
-	_, err := tx.Exec("DELETE FROM task WHERE rowid = $1", &taskID)
+	// sub-tasks are moved up to the parent of the deleted task
+	_, err := tx.Exec(
+		"UPDATE task SET parent_id = (SELECT parent_id FROM task WHERE rowid = $1) WHERE parent_id = $1",
+		&taskID)
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec("DELETE FROM pomodoro WHERE task_id = $1", &taskID)
+	_, err = tx.Exec("DELETE FROM task WHERE rowid = $1", &taskID)
+	if err != nil {
+		return err
+	}
+	err = s.DeletePomodoros(tx, taskID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM task_tag WHERE task_id = $1", &taskID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM break WHERE task_id = $1", &taskID)
 	if err != nil {
 		return err
 	}
@@ -110,19 +190,27 @@ func (s Store) DeleteTask(tx *sql.Tx, taskID int) error {
 func (s Store) ReadTask(tx *sql.Tx, taskID int) (*Task, error) {
 	task := &Task{}
 	var (
-		tags        string
 		strDuration string
+		project     sql.NullString
+		parentID    sql.NullInt64
 	)
-	err := tx.QueryRow(`SELECT rowid,message,pomodoros,duration,tags FROM task WHERE rowid = $1`, &taskID).
-		Scan(&task.ID, &task.Message, &task.NPomodoros, &strDuration, &tags)
+	err := tx.QueryRow(`
+	SELECT task.rowid,task.message,task.state,task.pomodoros,task.duration,project.name,task.parent_id,task.finished
+	FROM task LEFT JOIN project ON project.rowid = task.project_id
+	WHERE task.rowid = $1`, &taskID).
+		Scan(&task.ID, &task.Message, &task.State, &task.NPomodoros, &strDuration, &project, &parentID, &task.Finished)
 	if err != nil {
 		return nil, err
 	}
+	task.Project = project.String
+	task.ParentID = int(parentID.Int64)
 	duration, _ := time.ParseDuration(strDuration)
 	task.Duration = duration
-	if tags != "" {
-		task.Tags = strings.Split(tags, ",")
+	tags, err := s.ReadTaskTags(tx, task.ID)
+	if err != nil {
+		return nil, err
 	}
+	task.Tags = tags
 	pomodoros, err := s.ReadPomodoros(tx, task.ID)
 	if err != nil {
 		return nil, err
@@ -130,21 +218,51 @@ func (s Store) ReadTask(tx *sql.Tx, taskID int) (*Task, error) {
 	for _, pomodoro := range pomodoros {
 		task.Pomodoros = append(task.Pomodoros, pomodoro)
 	}
+	breaks, err := s.ReadBreaks(tx, task.ID)
+	if err != nil {
+		return nil, err
+	}
+	task.Breaks = breaks
 	return task, nil
 }
 
-func (s Store) CreatePomodoro(tx *sql.Tx, taskID int, pomodoro Pomodoro) error {
+// CreatePomodoro stores a pomodoro and its
+// pauses returning the ID of the pomodoro.
+func (s Store) CreatePomodoro(tx *sql.Tx, taskID int, pomodoro Pomodoro) (int, error) {
+	if pomodoro.Status == "" {
+		pomodoro.Status = PomodoroCompleted
+	}
 	_, err := tx.Exec(
-		`INSERT INTO pomodoro (task_id, start, end) VALUES ($1, $2, $3)`,
+		`INSERT INTO pomodoro (task_id, start, end, status) VALUES ($1, $2, $3, $4)`,
 		taskID,
 		pomodoro.Start,
 		pomodoro.End,
+		pomodoro.Status,
 	)
-	return err
+	if err != nil {
+		return -1, err
+	}
+	var pomodoroID int
+	err = tx.QueryRow("SELECT last_insert_rowid() FROM pomodoro").Scan(&pomodoroID)
+	if err != nil {
+		return -1, err
+	}
+	for _, pause := range pomodoro.Pauses {
+		_, err = tx.Exec(
+			`INSERT INTO pause (pomodoro_id, start, end) VALUES ($1, $2, $3)`,
+			pomodoroID,
+			pause.Start,
+			pause.End,
+		)
+		if err != nil {
+			return -1, err
+		}
+	}
+	return pomodoroID, nil
 }
 
 func (s Store) ReadPomodoros(tx *sql.Tx, taskID int) ([]*Pomodoro, error) {
-	rows, err := tx.Query(`SELECT start,end FROM pomodoro WHERE task_id = $1`, &taskID)
+	rows, err := tx.Query(`SELECT id,start,end,status FROM pomodoro WHERE task_id = $1 ORDER BY id`, &taskID)
 	if err != nil {
 		return nil, err
 	}
@@ -155,8 +273,9 @@ func (s Store) ReadPomodoros(tx *sql.Tx, taskID int) ([]*Pomodoro, error) {
 			endStr   string
 		)
 		pomodoro := &Pomodoro{}
-		err = rows.Scan(&startStr, &endStr)
+		err = rows.Scan(&pomodoro.ID, &startStr, &endStr, &pomodoro.Status)
 		if err != nil {
+			rows.Close()
 			return nil, err
 		}
 		start, _ := time.Parse(datetimeFmt, startStr)
@@ -165,30 +284,419 @@ func (s Store) ReadPomodoros(tx *sql.Tx, taskID int) ([]*Pomodoro, error) {
 		pomodoro.End = end
 		pomodoros = append(pomodoros, pomodoro)
 	}
+	rows.Close()
+	for _, pomodoro := range pomodoros {
+		pauses, err := s.ReadPauses(tx, pomodoro.ID)
+		if err != nil {
+			return nil, err
+		}
+		pomodoro.Pauses = pauses
+	}
 	return pomodoros, nil
 }
 
+// ReadPomodoro returns a single pomodoro and
+// the ID of the task it belongs to.
+func (s Store) ReadPomodoro(tx *sql.Tx, pomodoroID int) (*Pomodoro, int, error) {
+	var (
+		taskID   int
+		startStr string
+		endStr   string
+	)
+	pomodoro := &Pomodoro{ID: pomodoroID}
+	err := tx.QueryRow(`SELECT task_id,start,end,status FROM pomodoro WHERE id = $1`, pomodoroID).
+		Scan(&taskID, &startStr, &endStr, &pomodoro.Status)
+	if err != nil {
+		return nil, -1, err
+	}
+	pomodoro.Start, _ = time.Parse(datetimeFmt, startStr)
+	pomodoro.End, _ = time.Parse(datetimeFmt, endStr)
+	pomodoro.Pauses, err = s.ReadPauses(tx, pomodoroID)
+	if err != nil {
+		return nil, -1, err
+	}
+	return pomodoro, taskID, nil
+}
+
+// UpdatePomodoro replaces the start, end and status
+// of a stored pomodoro, its pauses are unchanged.
+func (s Store) UpdatePomodoro(tx *sql.Tx, pomodoro Pomodoro) error {
+	result, err := tx.Exec(
+		`UPDATE pomodoro SET start = $1, end = $2, status = $3 WHERE id = $4`,
+		pomodoro.Start,
+		pomodoro.End,
+		pomodoro.Status,
+		pomodoro.ID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeletePomodoro removes a single pomodoro and its pauses
+func (s Store) DeletePomodoro(tx *sql.Tx, pomodoroID int) error {
+	_, err := tx.Exec("DELETE FROM pause WHERE pomodoro_id = $1", pomodoroID)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM pomodoro WHERE id = $1", pomodoroID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func (s Store) DeletePomodoros(tx *sql.Tx, taskID int) error {
-	_, err := tx.Exec("DELETE FROM pomodoro WHERE task_id = $1", &taskID)
+	_, err := tx.Exec(
+		"DELETE FROM pause WHERE pomodoro_id IN (SELECT id FROM pomodoro WHERE task_id = $1)", &taskID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM pomodoro WHERE task_id = $1", &taskID)
+	return err
+}
+
+// ReadPauses returns each interval the
+// pomodoro was suspended for.
+func (s Store) ReadPauses(tx *sql.Tx, pomodoroID int) ([]*Pause, error) {
+	rows, err := tx.Query(`SELECT start,end FROM pause WHERE pomodoro_id = $1 ORDER BY start`, pomodoroID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pauses := []*Pause{}
+	for rows.Next() {
+		pause := &Pause{}
+		err = rows.Scan(&pause.Start, &pause.End)
+		if err != nil {
+			return nil, err
+		}
+		pauses = append(pauses, pause)
+	}
+	return pauses, rows.Err()
+}
+
+func (s Store) CreateBreak(tx *sql.Tx, taskID int, b Break) error {
+	_, err := tx.Exec(
+		`INSERT INTO break (task_id, start, end, planned) VALUES ($1, $2, $3, $4)`,
+		taskID,
+		b.Start,
+		b.End,
+		b.Planned.String(),
+	)
 	return err
 }
 
+func (s Store) ReadBreaks(tx *sql.Tx, taskID int) ([]*Break, error) {
+	rows, err := tx.Query(`SELECT start,end,planned FROM break WHERE task_id = $1`, &taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	breaks := []*Break{}
+	for rows.Next() {
+		var plannedStr string
+		b := &Break{}
+		err = rows.Scan(&b.Start, &b.End, &plannedStr)
+		if err != nil {
+			return nil, err
+		}
+		b.Planned, _ = time.ParseDuration(plannedStr)
+		breaks = append(breaks, b)
+	}
+	return breaks, rows.Err()
+}
+
+// SaveCheckpoint replaces the checkpoint
+// of the running session.
+func (s Store) SaveCheckpoint(tx *sql.Tx, checkpoint Checkpoint) error {
+	pauses, err := json.Marshal(checkpoint.Pauses)
+	if err != nil {
+		return err
+	}
+	err = s.DeleteCheckpoint(tx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO checkpoint (task_id,state,count,target,started,duration,remaining,pauses,updated,pid)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+		checkpoint.TaskID,
+		checkpoint.State,
+		checkpoint.Count,
+		checkpoint.Target,
+		checkpoint.Started,
+		checkpoint.Duration.String(),
+		checkpoint.Remaining.String(),
+		string(pauses),
+		checkpoint.Updated,
+		checkpoint.PID,
+	)
+	return err
+}
+
+// ReadCheckpoint returns the checkpoint of the last
+// session or nil if it concluded normally.
+func (s Store) ReadCheckpoint(tx *sql.Tx) (*Checkpoint, error) {
+	var (
+		strDuration  string
+		strRemaining string
+		pauses       string
+	)
+	checkpoint := &Checkpoint{}
+	err := tx.QueryRow(`
+	SELECT task_id,state,count,target,started,duration,remaining,pauses,updated,pid FROM checkpoint`).
+		Scan(&checkpoint.TaskID, &checkpoint.State, &checkpoint.Count, &checkpoint.Target, &checkpoint.Started,
+			&strDuration, &strRemaining, &pauses, &checkpoint.Updated, &checkpoint.PID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.Duration, _ = time.ParseDuration(strDuration)
+	checkpoint.Remaining, _ = time.ParseDuration(strRemaining)
+	err = json.Unmarshal([]byte(pauses), &checkpoint.Pauses)
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+func (s Store) DeleteCheckpoint(tx *sql.Tx) error {
+	_, err := tx.Exec("DELETE FROM checkpoint")
+	return err
+}
+
+// AbandonCheckpoint discards the checkpoint of a session that
+// was never restored, recording its pomodoro as abandoned.
+func (s Store) AbandonCheckpoint(tx *sql.Tx) error {
+	checkpoint, err := s.ReadCheckpoint(tx)
+	if err != nil || checkpoint == nil {
+		return err
+	}
+	if checkpoint.State == RUNNING || checkpoint.State == PAUSED {
+		_, err = s.CreatePomodoro(tx, checkpoint.TaskID, Pomodoro{
+			Start:  checkpoint.Started,
+			End:    checkpoint.Updated,
+			Status: PomodoroAbandoned,
+			Pauses: checkpoint.Pauses,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.DeleteCheckpoint(tx)
+}
+
+// ReadTaskTags returns the tags of a single task
+// in the order they were added.
+func (s Store) ReadTaskTags(tx *sql.Tx, taskID int) ([]string, error) {
+	rows, err := tx.Query(`
+	SELECT tag.name FROM task_tag
+	JOIN tag ON tag.rowid = task_tag.tag_id
+	WHERE task_tag.task_id = $1
+	ORDER BY task_tag.rowid`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tags []string
+	for rows.Next() {
+		var tag string
+		err = rows.Scan(&tag)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// ReadTags returns the name of every known tag.
+func (s Store) ReadTags(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query(`SELECT name FROM tag ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		err = rows.Scan(&tag)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// RenameTag renames a tag, if a tag with the new name
+// already exists the two tags are merged.
+func (s Store) RenameTag(tx *sql.Tx, from, to string) error {
+	var fromID, toID int
+	err := tx.QueryRow("SELECT rowid FROM tag WHERE name = $1", from).Scan(&fromID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("tag %s does not exist", from)
+		}
+		return err
+	}
+	err = tx.QueryRow("SELECT rowid FROM tag WHERE name = $1", to).Scan(&toID)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec("UPDATE tag SET name = $1 WHERE rowid = $2", to, fromID)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if fromID == toID {
+		return nil
+	}
+	_, err = tx.Exec(
+		"INSERT OR IGNORE INTO task_tag (task_id,tag_id) SELECT task_id,$1 FROM task_tag WHERE tag_id = $2",
+		toID, fromID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM task_tag WHERE tag_id = $1", fromID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tag WHERE rowid = $1", fromID)
+	return err
+}
+
+// ReadProjects returns the name of every known project.
+func (s Store) ReadProjects(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query(`SELECT name FROM project ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	projects := []string{}
+	for rows.Next() {
+		var project string
+		err = rows.Scan(&project)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+	return projects, rows.Err()
+}
+
+// subtree is a recursive query of the IDs of
+// a task and all of its sub-tasks.
+const subtree = `
+	WITH RECURSIVE subtree(id) AS (
+	    SELECT ?
+	    UNION SELECT task.rowid FROM task JOIN subtree ON task.parent_id = subtree.id
+	) SELECT id FROM subtree`
+
+// MoveTask moves a task and all of its sub-tasks
+// to the project, an empty project removes them
+// from any project.
+func (s Store) MoveTask(tx *sql.Tx, taskID int, project string) error {
+	_, err := s.ReadTask(tx, taskID)
+	if err != nil {
+		return err
+	}
+	projectID, err := ensureProject(tx, project)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE task SET project_id = ? WHERE rowid IN (`+subtree+`)`, projectID, taskID)
+	return err
+}
+
+// SetTaskParent makes a task a sub-task of another,
+// a parentID of zero makes it a top level task.
+func (s Store) SetTaskParent(tx *sql.Tx, taskID, parentID int) error {
+	_, err := s.ReadTask(tx, taskID)
+	if err != nil {
+		return err
+	}
+	if parentID == 0 {
+		_, err = tx.Exec("UPDATE task SET parent_id = NULL WHERE rowid = $1", taskID)
+		return err
+	}
+	_, err = s.ReadTask(tx, parentID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("parent task %d does not exist", parentID)
+		}
+		return err
+	}
+	var cycle bool
+	err = tx.QueryRow(`SELECT ? IN (`+subtree+`)`, parentID, taskID).Scan(&cycle)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return fmt.Errorf("task %d cannot be a sub-task of itself or of its own sub-tasks", taskID)
+	}
+	_, err = tx.Exec("UPDATE task SET parent_id = $1 WHERE rowid = $2", parentID, taskID)
+	return err
+}
+
+// ensureProject returns the ID of the project, creating
+// it if needed, or NULL if the project is empty.
+func ensureProject(tx *sql.Tx, project string) (sql.NullInt64, error) {
+	if project == "" {
+		return sql.NullInt64{}, nil
+	}
+	_, err := tx.Exec("INSERT OR IGNORE INTO project (name) VALUES ($1)", project)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+	var projectID int64
+	err = tx.QueryRow("SELECT rowid FROM project WHERE name = $1", project).Scan(&projectID)
+	return sql.NullInt64{Int64: projectID, Valid: true}, err
+}
+
+// setTaskTags associates each tag with the task,
+// creating any tags that do not yet exist.
+func setTaskTags(tx *sql.Tx, taskID int, tags []string) error {
+	for _, tag := range tags {
+		if tag == "" {
+			continue
+		}
+		_, err := tx.Exec("INSERT OR IGNORE INTO tag (name) VALUES ($1)", tag)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"INSERT OR IGNORE INTO task_tag (task_id,tag_id) SELECT $1,rowid FROM tag WHERE name = $2",
+			taskID, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s Store) Close() error { return s.db.Close() }
 
+// InitDB creates or upgrades the database
+// schema to the latest version.
 func InitDB(db *Store) error {
-	stmt := `
-    CREATE TABLE task (
-	message TEXT,
-	pomodoros INTEGER,
-	duration TEXT,
-	tags TEXT
-    );
-    CREATE TABLE pomodoro (
-	task_id INTEGER,
-	start DATETTIME,
-	end DATETTIME
-    );
-    `
-	_, err := db.db.Exec(stmt)
+	_, _, err := MigrateDB(db)
 	return err
 }
@@ -0,0 +1,701 @@
+package pomo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func initTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(path.Join(t.TempDir(), "pomo.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitDB(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestInitDBTwice(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	if err := InitDB(store); err != nil {
+		t.Fatal(err)
+	}
+	store.With(func(tx *sql.Tx) error {
+		version, err := store.Version(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != SchemaVersion() {
+			t.Fatalf("expected schema version %d, got %d", SchemaVersion(), version)
+		}
+		return nil
+	})
+}
+
+func TestMigrateLegacyDB(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "pomo.db")
+	store, err := OpenStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// schema created by pomo before versioning
+	err = store.With(migrations[0].up, func(tx *sql.Tx) error {
+		// the rowids of the rebuilt task table
+		// must match the pomodoros and tags
+		_, err := tx.Exec(`
+	INSERT INTO task (message,pomodoros,duration,tags) VALUES ('legacy',4,'25m0s','a,b');
+	INSERT INTO task (message,pomodoros,duration,tags) VALUES ('deleted',1,'25m0s','');
+	INSERT INTO task (message,pomodoros,duration,tags) VALUES ('after',2,'25m0s','c');
+	DELETE FROM task WHERE rowid = 2;
+	INSERT INTO pomodoro (task_id,start,end) VALUES (3,'2022-01-01T12:00:00Z','2022-01-01T12:25:00Z');
+	`)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	_, err = NewStore(dbPath)
+	if err != ErrSchemaOutdated {
+		t.Fatalf("expected %s, got %v", ErrSchemaOutdated, err)
+	}
+	store, err = OpenStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, to, err := MigrateDB(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 1 || to != SchemaVersion() {
+		t.Fatalf("expected migration from 1 to %d, got %d to %d", SchemaVersion(), from, to)
+	}
+	store.Close()
+	store, err = NewStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.With(func(tx *sql.Tx) error {
+		task, err := store.ReadTask(tx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.Message != "legacy" {
+			t.Fatalf("expected legacy task to be preserved, got %s", task.Message)
+		}
+		if len(task.Tags) != 2 || task.Tags[0] != "a" || task.Tags[1] != "b" {
+			t.Fatalf("expected legacy tags [a b], got %v", task.Tags)
+		}
+		task, err = store.ReadTask(tx, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.Message != "after" || len(task.Tags) != 1 || task.Tags[0] != "c" || len(task.Pomodoros) != 1 {
+			t.Fatalf("expected the task after a deleted one to keep its id, got %+v", task)
+		}
+		var columns int
+		err = tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info('task') WHERE name = 'tags'").Scan(&columns)
+		if err != nil || columns != 0 {
+			t.Fatalf("expected the tags column to be removed, got %d %v", columns, err)
+		}
+		err = tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info('task') WHERE name = 'id' AND pk = 1").Scan(&columns)
+		if err != nil || columns != 1 {
+			t.Fatalf("expected task to have an id primary key, got %d %v", columns, err)
+		}
+		return nil
+	})
+	// rowids are only kept by VACUUM for an INTEGER PRIMARY KEY
+	if _, err = store.db.Exec("VACUUM"); err != nil {
+		t.Fatal(err)
+	}
+	store.With(func(tx *sql.Tx) error {
+		task, err := store.ReadTask(tx, 3)
+		if err != nil || task.Message != "after" || len(task.Pomodoros) != 1 {
+			t.Fatalf("expected task 3 to keep its id after VACUUM, got %+v %v", task, err)
+		}
+		return nil
+	})
+}
+
+func TestNewStoreNewerSchema(t *testing.T) {
+	store := initTestStore(t)
+	_, err := store.db.Exec(
+		"INSERT INTO schema_version (version,description) VALUES ($1,'future')", SchemaVersion()+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.checkVersion(); err == nil {
+		t.Fatal("expected newer schema version to be refused")
+	}
+	store.Close()
+}
+
+func TestTags(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	err := store.With(func(tx *sql.Tx) error {
+		_, err := store.CreateTask(tx, Task{Message: "one", Tags: []string{"a,b", "c"}})
+		if err != nil {
+			return err
+		}
+		_, err = store.CreateTask(tx, Task{Message: "two", Tags: []string{"c", "d"}})
+		if err != nil {
+			return err
+		}
+		task, err := store.ReadTask(tx, 1)
+		if err != nil {
+			return err
+		}
+		if len(task.Tags) != 2 || task.Tags[0] != "a,b" {
+			t.Fatalf("expected tags [a,b c], got %v", task.Tags)
+		}
+		tasks, err := store.ReadTasksByTag(tx, "c")
+		if err != nil {
+			return err
+		}
+		if len(tasks) != 2 {
+			t.Fatalf("expected 2 tasks tagged c, got %d", len(tasks))
+		}
+		// merge d into c
+		err = store.RenameTag(tx, "d", "c")
+		if err != nil {
+			return err
+		}
+		task, err = store.ReadTask(tx, 2)
+		if err != nil {
+			return err
+		}
+		if len(task.Tags) != 1 || task.Tags[0] != "c" {
+			t.Fatalf("expected tags [c], got %v", task.Tags)
+		}
+		err = store.RenameTag(tx, "c", "e")
+		if err != nil {
+			return err
+		}
+		tags, err := store.ReadTags(tx)
+		if err != nil {
+			return err
+		}
+		if len(tags) != 2 || tags[0] != "a,b" || tags[1] != "e" {
+			t.Fatalf("expected tags [a,b e], got %v", tags)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBreaks(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	start := time.Date(2022, 1, 1, 12, 0, 0, 0, time.Local)
+	err := store.With(func(tx *sql.Tx) error {
+		taskID, err := store.CreateTask(tx, Task{Message: "one"})
+		if err != nil {
+			return err
+		}
+		err = store.CreateBreak(tx, taskID, Break{
+			Start:   start,
+			End:     start.Add(7 * time.Minute),
+			Planned: 5 * time.Minute,
+		})
+		if err != nil {
+			return err
+		}
+		task, err := store.ReadTask(tx, taskID)
+		if err != nil {
+			return err
+		}
+		if len(task.Breaks) != 1 {
+			t.Fatalf("expected 1 break, got %d", len(task.Breaks))
+		}
+		if task.Breaks[0].Planned != 5*time.Minute || task.Breaks[0].Duration() != 7*time.Minute {
+			t.Fatalf("unexpected break %v", task.Breaks[0])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPauses(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	start := time.Date(2022, 1, 3, 9, 0, 0, 0, time.UTC)
+	// written out of order, they are read back by start
+	pauses := []*Pause{
+		{Start: start.Add(10 * time.Minute), End: start.Add(13*time.Minute + 30*time.Second)},
+		{Start: start.Add(2 * time.Minute), End: start.Add(3 * time.Minute)},
+		{Start: start.Add(20 * time.Minute), End: start.Add(20*time.Minute + 15*time.Second)},
+	}
+	expected := []*Pause{pauses[1], pauses[0], pauses[2]}
+	paused := 4*time.Minute + 45*time.Second
+	checkPauses := func(from string, pomodoro *Pomodoro) {
+		t.Helper()
+		if len(pomodoro.Pauses) != len(expected) {
+			t.Fatalf("%s: expected %d pauses, got %d", from, len(expected), len(pomodoro.Pauses))
+		}
+		for i, pause := range pomodoro.Pauses {
+			if !pause.Start.Equal(expected[i].Start) || !pause.End.Equal(expected[i].End) {
+				t.Fatalf("%s: expected pause %d from %s to %s, got %s to %s",
+					from, i, expected[i].Start, expected[i].End, pause.Start, pause.End)
+			}
+		}
+		if pomodoro.Paused() != paused {
+			t.Fatalf("%s: expected %s paused, got %s", from, paused, pomodoro.Paused())
+		}
+	}
+	err := store.With(func(tx *sql.Tx) error {
+		taskID, err := store.CreateTask(tx, Task{Message: "paused", NPomodoros: 2, Duration: 25 * time.Minute})
+		if err != nil {
+			return err
+		}
+		_, err = store.CreatePomodoro(tx, taskID, Pomodoro{
+			Start:  start,
+			End:    start.Add(30 * time.Minute),
+			Pauses: pauses,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = store.CreatePomodoro(tx, taskID, Pomodoro{
+			Start: start.Add(time.Hour),
+			End:   start.Add(time.Hour + 25*time.Minute),
+		})
+		if err != nil {
+			return err
+		}
+		pomodoros, err := store.ReadPomodoros(tx, taskID)
+		if err != nil {
+			return err
+		}
+		if len(pomodoros) != 2 || len(pomodoros[1].Pauses) != 0 {
+			t.Fatalf("expected only the first pomodoro to be paused, got %v", pomodoros)
+		}
+		checkPauses("ReadPomodoros", pomodoros[0])
+		tasks, err := store.ReadTasks(tx)
+		if err != nil {
+			return err
+		}
+		if len(tasks) != 1 || len(tasks[0].Pomodoros) != 2 || len(tasks[0].Pomodoros[1].Pauses) != 0 {
+			t.Fatalf("unexpected tasks %v", tasks)
+		}
+		checkPauses("ReadTasks", tasks[0].Pomodoros[0])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	start := time.Date(2022, 1, 1, 12, 0, 0, 0, time.Local)
+	err := store.With(func(tx *sql.Tx) error {
+		checkpoint, err := store.ReadCheckpoint(tx)
+		if err != nil {
+			return err
+		}
+		if checkpoint != nil {
+			t.Fatalf("expected no checkpoint, got %v", checkpoint)
+		}
+		err = store.SaveCheckpoint(tx, Checkpoint{
+			TaskID:    1,
+			State:     RUNNING,
+			Count:     2,
+			Started:   start,
+			Duration:  25 * time.Minute,
+			Remaining: 10 * time.Minute,
+			Pauses:    []*Pause{{Start: start.Add(time.Minute), End: start.Add(2 * time.Minute)}},
+			Updated:   start.Add(16 * time.Minute),
+		})
+		if err != nil {
+			return err
+		}
+		checkpoint, err = store.ReadCheckpoint(tx)
+		if err != nil {
+			return err
+		}
+		if checkpoint.State != RUNNING || checkpoint.Count != 2 || checkpoint.Remaining != 10*time.Minute {
+			t.Fatalf("unexpected checkpoint %v", checkpoint)
+		}
+		if len(checkpoint.Pauses) != 1 || checkpoint.Pauses[0].Duration() != time.Minute {
+			t.Fatalf("unexpected checkpoint pauses %v", checkpoint.Pauses)
+		}
+		err = store.AbandonCheckpoint(tx)
+		if err != nil {
+			return err
+		}
+		checkpoint, err = store.ReadCheckpoint(tx)
+		if err != nil {
+			return err
+		}
+		if checkpoint != nil {
+			t.Fatalf("expected checkpoint to be removed, got %v", checkpoint)
+		}
+		pomodoros, err := store.ReadPomodoros(tx, 1)
+		if err != nil {
+			return err
+		}
+		if len(pomodoros) != 1 || pomodoros[0].Status != PomodoroAbandoned {
+			t.Fatalf("expected a single abandoned pomodoro, got %v", pomodoros)
+		}
+		if pomodoros[0].Worked() != 15*time.Minute {
+			t.Fatalf("expected 15m worked, got %s", pomodoros[0].Worked())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryTasks(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	day := time.Date(2022, 1, 3, 9, 0, 0, 0, time.UTC)
+	err := store.With(func(tx *sql.Tx) error {
+		for i, task := range []Task{
+			{Message: "Write code", NPomodoros: 1, Tags: []string{"work", "code"}},
+			{Message: "read mail", NPomodoros: 2, Tags: []string{"work"}},
+			{Message: "Plan", NPomodoros: 1},
+		} {
+			taskID, err := store.CreateTask(tx, task)
+			if err != nil {
+				return err
+			}
+			if i == 2 {
+				// never started
+				continue
+			}
+			start := day.AddDate(0, 0, i)
+			_, err = store.CreatePomodoro(tx, taskID, Pomodoro{
+				Start:  start,
+				End:    start.Add(25 * time.Minute),
+				Pauses: []*Pause{{Start: start.Add(time.Minute), End: start.Add(2 * time.Minute)}},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return store.CreateBreak(tx, 1, Break{Start: day, End: day.Add(time.Minute), Planned: time.Minute})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		query    TaskQuery
+		expected []int
+	}{
+		{TaskQuery{}, []int{1, 2, 3}},
+		{TaskQuery{Since: day.Add(time.Hour)}, []int{2}},
+		{TaskQuery{Until: day.Add(time.Hour)}, []int{1}},
+		{TaskQuery{Tags: []string{"code", "work"}}, []int{1, 2}},
+		{TaskQuery{Tags: []string{"code", "work"}, AllTags: true}, []int{1}},
+		{TaskQuery{Grep: "CODE"}, []int{1}},
+		{TaskQuery{Progress: ProgressNotStarted}, []int{3}},
+		{TaskQuery{Progress: ProgressIncomplete}, []int{2}},
+		{TaskQuery{Progress: ProgressComplete}, []int{1}},
+		{TaskQuery{Sort: SortByMessage}, []int{3, 2, 1}},
+		{TaskQuery{Descending: true, Limit: 2}, []int{3, 2}},
+		{TaskQuery{Limit: 1, Offset: 1}, []int{2}},
+		{TaskQuery{Offset: 2}, []int{3}},
+	} {
+		var tasks []*Task
+		err := store.With(func(tx *sql.Tx) error {
+			tasks, err = store.QueryTasks(tx, test.query)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, task := range tasks {
+			ids = append(ids, task.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.expected) {
+			t.Fatalf("expected tasks %v for %+v, got %v", test.expected, test.query, ids)
+		}
+	}
+
+	var task *Task
+	err = store.With(func(tx *sql.Tx) error {
+		tasks, err := store.QueryTasks(tx, TaskQuery{Tags: []string{"code"}})
+		if len(tasks) == 1 {
+			task = tasks[0]
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(task.Tags) != 2 || task.Tags[0] != "work" {
+		t.Fatalf("expected tags [work code], got %v", task.Tags)
+	}
+	if len(task.Pomodoros) != 1 || !task.Pomodoros[0].Start.Equal(day) {
+		t.Fatalf("unexpected pomodoros %v", task.Pomodoros)
+	}
+	if len(task.Pomodoros[0].Pauses) != 1 || task.Pomodoros[0].Paused() != time.Minute {
+		t.Fatalf("unexpected pauses %v", task.Pomodoros[0].Pauses)
+	}
+	if len(task.Breaks) != 1 || task.Breaks[0].Duration() != time.Minute || task.Breaks[0].Planned != time.Minute {
+		t.Fatalf("unexpected breaks %v", task.Breaks)
+	}
+	err = store.With(func(tx *sql.Tx) error {
+		_, err := store.QueryTasks(tx, TaskQuery{Sort: "bogus"})
+		return err
+	})
+	if err == nil {
+		t.Fatal("expected an unknown sort to fail")
+	}
+}
+
+func TestPomodoroIDs(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	start := time.Date(2022, 1, 3, 9, 0, 0, 0, time.UTC)
+	err := store.With(func(tx *sql.Tx) error {
+		taskID, err := store.CreateTask(tx, Task{Message: "one", NPomodoros: 2})
+		if err != nil {
+			return err
+		}
+		var ids []int
+		for i := 0; i < 2; i++ {
+			pomodoroID, err := store.CreatePomodoro(tx, taskID, Pomodoro{
+				Start:  start.Add(time.Duration(i) * time.Hour),
+				End:    start.Add(time.Duration(i)*time.Hour + 25*time.Minute),
+				Pauses: []*Pause{{Start: start, End: start.Add(time.Minute)}},
+			})
+			if err != nil {
+				return err
+			}
+			ids = append(ids, pomodoroID)
+		}
+		pomodoro, readTaskID, err := store.ReadPomodoro(tx, ids[0])
+		if err != nil {
+			return err
+		}
+		if readTaskID != taskID || !pomodoro.Start.Equal(start) || len(pomodoro.Pauses) != 1 {
+			t.Fatalf("unexpected pomodoro %+v of task %d", pomodoro, readTaskID)
+		}
+		pomodoro.End = start.Add(10 * time.Minute)
+		pomodoro.Status = PomodoroInterrupted
+		err = store.UpdatePomodoro(tx, *pomodoro)
+		if err != nil {
+			return err
+		}
+		err = store.DeletePomodoro(tx, ids[1])
+		if err != nil {
+			return err
+		}
+		if err = store.DeletePomodoro(tx, ids[1]); err != sql.ErrNoRows {
+			t.Fatalf("expected %s deleting a missing pomodoro, got %v", sql.ErrNoRows, err)
+		}
+		var count int
+		err = tx.QueryRow("SELECT COUNT(*) FROM pause").Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count != 1 {
+			t.Fatalf("expected the pause of the deleted pomodoro to be removed, got %d", count)
+		}
+		task, err := store.ReadTask(tx, taskID)
+		if err != nil {
+			return err
+		}
+		if len(task.Pomodoros) != 1 || task.Pomodoros[0].ID != ids[0] {
+			t.Fatalf("unexpected pomodoros %v", task.Pomodoros)
+		}
+		if task.Pomodoros[0].Duration() != 10*time.Minute || task.Pomodoros[0].Status != PomodoroInterrupted {
+			t.Fatalf("expected the update to be stored, got %+v", task.Pomodoros[0])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTaskStates(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	finished := time.Date(2022, 1, 3, 9, 0, 0, 0, time.FixedZone("CET", 3600))
+	err := store.With(func(tx *sql.Tx) error {
+		for _, message := range []string{"one", "two", "three"} {
+			_, err := store.CreateTask(tx, Task{Message: message})
+			if err != nil {
+				return err
+			}
+		}
+		task, err := store.ReadTask(tx, 1)
+		if err != nil {
+			return err
+		}
+		if task.State != TaskTodo {
+			t.Fatalf("expected new tasks to be todo, got %s", task.State)
+		}
+		err = store.SetTaskState(tx, 2, TaskArchived, finished)
+		if err != nil {
+			return err
+		}
+		err = store.SetTaskState(tx, 3, TaskDone, finished)
+		if err != nil {
+			return err
+		}
+		if err = store.SetTaskState(tx, 4, TaskDone, finished); err != sql.ErrNoRows {
+			t.Fatalf("expected %s for a missing task, got %v", sql.ErrNoRows, err)
+		}
+		tasks, err := store.QueryTasks(tx, TaskQuery{States: []TaskState{TaskTodo, TaskDone}})
+		if err != nil {
+			return err
+		}
+		if len(tasks) != 2 || tasks[0].ID != 1 || tasks[1].ID != 3 || tasks[1].State != TaskDone {
+			t.Fatalf("unexpected tasks %v", tasks)
+		}
+		if tasks[0].Finished != nil || tasks[1].Finished == nil || !tasks[1].Finished.Equal(finished) {
+			t.Fatalf("expected only the done task to be finished at %s, got %v", finished, tasks)
+		}
+		if _, offset := tasks[1].Finished.Zone(); offset != 3600 {
+			t.Fatalf("expected the zone of the finished time to be kept, got %s", tasks[1].Finished)
+		}
+		if raw, _ := json.Marshal(tasks[0]); strings.Contains(string(raw), "finished") {
+			t.Fatalf("expected finished to be omitted, got %s", raw)
+		}
+		// archiving keeps the time a task was finished
+		// and starting it again clears it
+		for _, state := range []TaskState{TaskArchived, TaskActive} {
+			err = store.SetTaskState(tx, 3, state, finished.Add(time.Hour))
+			if err != nil {
+				return err
+			}
+			task, err = store.ReadTask(tx, 3)
+			if err != nil {
+				return err
+			}
+			if (task.Finished != nil && task.Finished.Equal(finished)) != (state == TaskArchived) {
+				t.Fatalf("expected only the archived task to be finished at %s, got %v", finished, task.Finished)
+			}
+		}
+		_, err = store.QueryTasks(tx, TaskQuery{States: []TaskState{"bogus"}})
+		if err == nil {
+			t.Fatal("expected an unknown state to fail")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProjects(t *testing.T) {
+	store := initTestStore(t)
+	defer store.Close()
+	err := store.With(func(tx *sql.Tx) error {
+		// 1 -> 2 -> 3, 4 has no project
+		_, err := store.CreateTask(tx, Task{Message: "site", Project: "web"})
+		if err != nil {
+			return err
+		}
+		_, err = store.CreateTask(tx, Task{Message: "pages", ParentID: 1})
+		if err != nil {
+			return err
+		}
+		_, err = store.CreateTask(tx, Task{Message: "about", ParentID: 2})
+		if err != nil {
+			return err
+		}
+		_, err = store.CreateTask(tx, Task{Message: "other"})
+		if err != nil {
+			return err
+		}
+		if _, err = store.CreateTask(tx, Task{Message: "orphan", ParentID: 9}); err == nil {
+			t.Fatal("expected a missing parent to fail")
+		}
+		task, err := store.ReadTask(tx, 3)
+		if err != nil {
+			return err
+		}
+		if task.Project != "web" || task.ParentID != 2 {
+			t.Fatalf("expected sub-tasks to inherit the project, got %v", task)
+		}
+		tasks, err := store.QueryTasks(tx, TaskQuery{Subtree: 2})
+		if err != nil {
+			return err
+		}
+		if len(tasks) != 2 || tasks[0].ID != 2 || tasks[1].ID != 3 || tasks[1].ParentID != 2 {
+			t.Fatalf("unexpected subtree %v", tasks)
+		}
+
+		err = store.MoveTask(tx, 2, "blog")
+		if err != nil {
+			return err
+		}
+		if err = store.MoveTask(tx, 9, "blog"); err != sql.ErrNoRows {
+			t.Fatalf("expected %s for a missing task, got %v", sql.ErrNoRows, err)
+		}
+		projects, err := store.ReadProjects(tx)
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(projects) != "[blog web]" {
+			t.Fatalf("unexpected projects %v", projects)
+		}
+		for project, expected := range map[string][]int{"web": {1}, "blog": {2, 3}} {
+			tasks, err = store.QueryTasks(tx, TaskQuery{Project: project})
+			if err != nil {
+				return err
+			}
+			var ids []int
+			for _, task := range tasks {
+				ids = append(ids, task.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(expected) {
+				t.Fatalf("expected tasks %v in %s, got %v", expected, project, ids)
+			}
+		}
+
+		if err = store.SetTaskParent(tx, 1, 3); err == nil {
+			t.Fatal("expected a task to not become a sub-task of its own sub-task")
+		}
+		if err = store.SetTaskParent(tx, 1, 1); err == nil {
+			t.Fatal("expected a task to not become a sub-task of itself")
+		}
+		err = store.SetTaskParent(tx, 3, 4)
+		if err != nil {
+			return err
+		}
+		// sub-tasks of a deleted task move up to its parent
+		err = store.SetTaskParent(tx, 4, 2)
+		if err != nil {
+			return err
+		}
+		err = store.DeleteTask(tx, 2)
+		if err != nil {
+			return err
+		}
+		task, err = store.ReadTask(tx, 4)
+		if err != nil {
+			return err
+		}
+		if task.ParentID != 1 {
+			t.Fatalf("expected task 4 to be a sub-task of 1, got %d", task.ParentID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
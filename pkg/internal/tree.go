@@ -0,0 +1,60 @@
+package pomo
+
+// TaskNode is a task along with its sub-tasks
+type TaskNode struct {
+	Task     *Task
+	Children []*TaskNode
+}
+
+// NewTaskTree arranges tasks by their parents, tasks whose parent
+// is not among the given tasks are returned as roots. The order
+// of the tasks is kept for roots and for the children of a task.
+func NewTaskTree(tasks []*Task) []*TaskNode {
+	nodes := map[int]*TaskNode{}
+	for _, task := range tasks {
+		nodes[task.ID] = &TaskNode{Task: task}
+	}
+	roots := []*TaskNode{}
+	for _, task := range tasks {
+		node := nodes[task.ID]
+		if parent, ok := nodes[task.ParentID]; ok && task.ParentID != task.ID {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// Walk calls fn for the node and then each of
+// its descendants along with their depth.
+func (n *TaskNode) Walk(fn func(node *TaskNode, depth int)) {
+	n.walk(fn, 0)
+}
+
+func (n *TaskNode) walk(fn func(*TaskNode, int), depth int) {
+	fn(n, depth)
+	for _, child := range n.Children {
+		child.walk(fn, depth+1)
+	}
+}
+
+// Planned returns the number of pomodoros
+// planned for the task and its sub-tasks.
+func (n *TaskNode) Planned() int {
+	planned := 0
+	n.Walk(func(node *TaskNode, _ int) {
+		planned += node.Task.NPomodoros
+	})
+	return planned
+}
+
+// Completed returns the number of pomodoros completed
+// for the task and its sub-tasks.
+func (n *TaskNode) Completed() int {
+	completed := 0
+	n.Walk(func(node *TaskNode, _ int) {
+		completed += node.Task.Completed()
+	})
+	return completed
+}
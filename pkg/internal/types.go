@@ -1,11 +1,9 @@
 package pomo
 
 import (
-	"io/ioutil"
-	"os"
+	"fmt"
+	"math"
 	"time"
-
-	"github.com/0xAX/notificator"
 )
 
 type State int
@@ -55,10 +53,34 @@ func (w *Wheel) String() string {
 	return ""
 }
 
+// TaskState is where a task is in its lifecycle
+type TaskState string
+
+const (
+	// TaskTodo tasks are waiting in the backlog
+	TaskTodo TaskState = "todo"
+	// TaskActive tasks have been started but not finished
+	TaskActive TaskState = "active"
+	// TaskDone tasks are finished
+	TaskDone TaskState = "done"
+	// TaskArchived tasks are hidden from list by default
+	TaskArchived TaskState = "archived"
+)
+
+// Valid reports whether the state is one of the known states
+func (s TaskState) Valid() bool {
+	switch s {
+	case TaskTodo, TaskActive, TaskDone, TaskArchived:
+		return true
+	}
+	return false
+}
+
 // Task describes some activity
 type Task struct {
-	ID      int    `json:"id"`
-	Message string `json:"message"`
+	ID      int       `json:"id"`
+	Message string    `json:"message"`
+	State   TaskState `json:"state"`
 	// Array of completed pomodoros
 	Pomodoros []*Pomodoro `json:"pomodoros"`
 	// Free-form tags associated with this task
@@ -67,6 +89,57 @@ type Task struct {
 	NPomodoros int `json:"n_pomodoros"`
 	// Duration of each pomodoro
 	Duration time.Duration `json:"duration"`
+	// Breaks taken between pomodoros
+	Breaks []*Break `json:"breaks"`
+	// Project the task belongs to if any
+	Project string `json:"project,omitempty"`
+	// ParentID is the ID of the task this
+	// is a sub-task of or zero for none
+	ParentID int `json:"parent_id,omitempty"`
+	// Finished is when the task was last marked as done
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Validate checks a task before it is stored
+func (t Task) Validate() error {
+	if t.Message == "" {
+		return fmt.Errorf("task message cannot be empty")
+	}
+	if t.NPomodoros < 1 || t.Duration <= 0 {
+		return fmt.Errorf("task needs at least one pomodoro with a positive duration")
+	}
+	// tasks without a state are stored as todo
+	if t.State != "" && !t.State.Valid() {
+		return fmt.Errorf("unknown task state %q", t.State)
+	}
+	return nil
+}
+
+// Completed returns the number of pomodoros
+// that ran for their full duration.
+func (t Task) Completed() int {
+	n := 0
+	for _, pomodoro := range t.Pomodoros {
+		if pomodoro.Status == PomodoroCompleted {
+			n++
+		}
+	}
+	return n
+}
+
+// Actual returns the number of pomodoros worked on the task to
+// compare with its estimate, pomodoros that were cut short count
+// as the fraction of the duration that was worked.
+func (t Task) Actual() float64 {
+	var actual float64
+	for _, pomodoro := range t.Pomodoros {
+		if pomodoro.Status == PomodoroCompleted || t.Duration <= 0 {
+			actual++
+			continue
+		}
+		actual += math.Min(float64(pomodoro.Worked())/float64(t.Duration), 1)
+	}
+	return actual
 }
 
 // ByID is a sortable array of tasks
@@ -90,11 +163,28 @@ func After(start time.Time, tasks []*Task) []*Task {
 	return filtered
 }
 
+// PomodoroStatus describes how a pomodoro ended
+type PomodoroStatus string
+
+const (
+	// PomodoroCompleted ran for its full duration
+	PomodoroCompleted PomodoroStatus = "completed"
+	// PomodoroInterrupted was stopped while running
+	PomodoroInterrupted PomodoroStatus = "interrupted"
+	// PomodoroAbandoned was never resumed after
+	// being paused or the session ended uncleanly
+	PomodoroAbandoned PomodoroStatus = "abandoned"
+)
+
 // Pomodoro is a unit of time to spend working
 // on a single task.
 type Pomodoro struct {
-	Start time.Time `json:"start"`
-	End   time.Time `json:"end"`
+	ID     int            `json:"id"`
+	Start  time.Time      `json:"start"`
+	End    time.Time      `json:"end"`
+	Status PomodoroStatus `json:"status"`
+	// Intervals during which the pomodoro was paused
+	Pauses []*Pause `json:"pauses"`
 }
 
 // Duration returns the runtime of the pomodoro
@@ -102,6 +192,71 @@ func (p Pomodoro) Duration() time.Duration {
 	return (p.End.Sub(p.Start))
 }
 
+// Paused returns the total time the pomodoro was paused
+func (p Pomodoro) Paused() time.Duration {
+	var paused time.Duration
+	for _, pause := range p.Pauses {
+		paused += pause.Duration()
+	}
+	return paused
+}
+
+// Worked returns the time spent focused on the
+// pomodoro excluding any pauses.
+func (p Pomodoro) Worked() time.Duration {
+	return p.Duration() - p.Paused()
+}
+
+// Pause is an interval during which
+// a pomodoro was suspended.
+type Pause struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Duration returns the length of the pause
+func (p Pause) Duration() time.Duration {
+	return (p.End.Sub(p.Start))
+}
+
+// Break is the rest taken between
+// two pomodoros.
+type Break struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Planned length of the break
+	Planned time.Duration `json:"planned"`
+}
+
+// Duration returns the actual length of the break
+func (b Break) Duration() time.Duration {
+	return (b.End.Sub(b.Start))
+}
+
+// Checkpoint captures the progress of a running
+// session so that it can be restored after a crash.
+type Checkpoint struct {
+	TaskID int   `json:"task_id"`
+	State  State `json:"state"`
+	Count  int   `json:"count"`
+	// Target is the number of pomodoros in
+	// the session which may differ from the
+	// estimate if it was extended
+	Target int `json:"target"`
+	// Start of the current pomodoro or break
+	Started time.Time `json:"started"`
+	// Planned length of the current pomodoro or break
+	Duration time.Duration `json:"duration"`
+	// Time remaining in the current pomodoro
+	// when the checkpoint was written
+	Remaining time.Duration `json:"remaining"`
+	// Pauses taken during the current pomodoro
+	Pauses  []*Pause  `json:"pauses"`
+	Updated time.Time `json:"updated"`
+	// PID of the process running the session
+	PID int `json:"pid"`
+}
+
 // Status is used to communicate the state
 // of a running Pomodoro session
 type Status struct {
@@ -110,41 +265,40 @@ type Status struct {
 	State         State         `json:"state"`
 	Remaining     time.Duration `json:"remaining"`
 	Pauseduration time.Duration `json:"pauseduration"`
+	BreakDuration time.Duration `json:"break_duration"`
 	Count         int           `json:"count"`
-	NPomodoros    int           `json:"n_pomodoros"`
-}
-
-// Notifier sends a system notification
-type Notifier interface {
-	Notify(string, string) error
+	// NPomodoros is the number of pomodoros in the session
+	NPomodoros int `json:"n_pomodoros"`
+	// Estimate is the number of pomodoros the task
+	// was expected to take
+	Estimate int `json:"estimate"`
 }
 
-// NoopNotifier does nothing
-type NoopNotifier struct{}
-
-func (n NoopNotifier) Notify(string, string) error { return nil }
+// EventType identifies a change
+// in a running session.
+type EventType string
 
-// Xnotifier can push notifications to mac, linux and windows.
-type Xnotifier struct {
-	*notificator.Notificator
-	iconPath string
-}
-
-func NewXnotifier(iconPath string) Notifier {
-	// Write the built-in tomato icon if it
-	// doesn't already exist.
-	_, err := os.Stat(iconPath)
-	if os.IsNotExist(err) {
-		raw := MustAsset("tomato-icon.png")
-		_ = ioutil.WriteFile(iconPath, raw, 0644)
-	}
-	return Xnotifier{
-		Notificator: notificator.New(notificator.Options{}),
-		iconPath:    iconPath,
-	}
-}
+const (
+	EventPomodoroStarted EventType = "pomodoro_started"
+	EventPomodoroEnded   EventType = "pomodoro_ended"
+	EventPaused          EventType = "paused"
+	EventResumed         EventType = "resumed"
+	EventBreakStarted    EventType = "break_started"
+	EventBreakEnded      EventType = "break_ended"
+	EventSessionComplete EventType = "session_complete"
+	// EventTick is sent every second to
+	// subscribers that request it.
+	EventTick EventType = "tick"
+)
 
-// Notify sends a notification to the OS.
-func (n Xnotifier) Notify(title, body string) error {
-	return n.Push(title, body, n.iconPath, notificator.UR_NORMAL)
+// Event is emitted by the TaskRunner after
+// each change to the state of the session.
+type Event struct {
+	Type   EventType `json:"type"`
+	Time   time.Time `json:"time"`
+	Status Status    `json:"status"`
+	// Pomodoro is set for pomodoro and pause events
+	Pomodoro *Pomodoro `json:"pomodoro,omitempty"`
+	// Break is set for break events
+	Break *Break `json:"break,omitempty"`
 }
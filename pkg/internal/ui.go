@@ -9,28 +9,50 @@ import (
 )
 
 func setContent(wheel *Wheel, status *Status, par *widgets.Paragraph) {
+	var estimate string
+	if status.NPomodoros != status.Estimate {
+		estimate = fmt.Sprintf(" (estimated %d)", status.Estimate)
+	}
 	switch status.State {
 	case RUNNING:
 		par.Text = fmt.Sprintf(
-			`[%d/%d] Pomodoros completed
+			`[%d/%d] Pomodoros completed%s
 
 			Current Task: %s
 
 			%s %s remaining
 
 
-			[q] - quit [p] - pause
+			[q] quit [p] pause [+] extend [f] finish
 			`,
 			status.Count,
 			status.NPomodoros,
+			estimate,
 			status.TaskMessage,
 			wheel,
 			status.Remaining,
 		)
 	case BREAKING:
 
-		par.Text = fmt.Sprintf(
-			`It is time to take a break!
+		if status.Remaining > 0 {
+			par.Text = fmt.Sprintf(
+				`It is time to take a %s break!
+
+			Press [Enter] to skip the rest of
+			the break and begin the next Pomodoro
+
+			%s %s remaining
+
+
+			[q] quit [+] extend [f] finish
+			`,
+				status.BreakDuration,
+				wheel,
+				status.Remaining,
+			)
+		} else {
+			par.Text = fmt.Sprintf(
+				`Your break is over!
 
 			Once you are ready, press [Enter]
 			to begin the next Pomodoro
@@ -38,11 +60,12 @@ func setContent(wheel *Wheel, status *Status, par *widgets.Paragraph) {
 			%s %s break duration
 
 
-			[q] - quit
+			[q] quit [+] extend [f] finish
 			`,
-			wheel,
-			status.Pauseduration,
-		)
+				wheel,
+				status.Pauseduration,
+			)
+		}
 	case PAUSED:
 		par.Text = fmt.Sprintf(`Pomo is suspended.
 			
@@ -96,7 +119,7 @@ func StartUI(runner *TaskRunner) {
 		y1 := (termHeight - 10) / 2
 		y2 := y1 + 10
 
-		switch runner.state {
+		switch runner.Status().State {
 		case BREAKING:
 			y1 = (termHeight - 11) / 2
 			y2 = y1 + 11
@@ -120,11 +143,15 @@ func StartUI(runner *TaskRunner) {
 	events := ui.PollEvents()
 
 	for {
-		laststate := runner.state
+		laststate := runner.Status().State
 		select {
 		case e := <-events:
 			switch e.ID {
 			case "q", "<C-c>":
+				// record any partial pomodoro before exiting
+				if runner.Stop() == nil {
+					<-runner.Done()
+				}
 				return
 			case "<Resize>":
 				resize()
@@ -134,13 +161,20 @@ func StartUI(runner *TaskRunner) {
 				resize()
 				render()
 			case "p":
-				runner.Pause()
+				runner.TogglePause()
+				render()
+			case "+":
+				runner.Extend(1)
+				render()
+			case "f":
+				runner.Finish()
+				resize()
 				render()
 			}
 		case <-ticker.C:
-			if runner.state != laststate {
+			if state := runner.Status().State; state != laststate {
 				resize()
-				laststate = runner.state
+				laststate = state
 			}
 			render()
 		}
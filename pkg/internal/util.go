@@ -2,6 +2,7 @@ package pomo
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/fatih/color"
@@ -9,62 +10,91 @@ import (
 
 func SummerizeTasks(config *Config, tasks []*Task) {
 	for _, task := range tasks {
-		var start string
-		if len(task.Pomodoros) > 0 {
-			start = task.Pomodoros[0].Start.Format(config.DateTimeFmt)
-		}
-		fmt.Printf("%d: [%s] [%s] ", task.ID, start, task.Duration.Truncate(time.Second))
-		// a list of green/yellow/red pomodoros
-		// green indicates the pomodoro was finished normally
-		// yellow indicates the break was exceeded by +5minutes
-		// red indicates the pomodoro was never completed
-		fmt.Printf("[")
-		for i, pomodoro := range task.Pomodoros {
-			if i > 0 {
-				fmt.Printf(" ")
+		summerizeTask(config, task, "", "")
+	}
+}
+
+// SummerizeTaskTree prints each task below its parent,
+// tasks with sub-tasks are followed by the number of
+// pomodoros completed and planned for the whole tree.
+func SummerizeTaskTree(config *Config, tasks []*Task) {
+	for _, root := range NewTaskTree(tasks) {
+		root.Walk(func(node *TaskNode, depth int) {
+			var rollup string
+			if len(node.Children) > 0 {
+				rollup = fmt.Sprintf(" (%d/%d)", node.Completed(), node.Planned())
 			}
+			summerizeTask(config, node.Task, strings.Repeat("  ", depth), rollup)
+		})
+	}
+}
+
+func summerizeTask(config *Config, task *Task, indent, suffix string) {
+	var start string
+	if len(task.Pomodoros) > 0 {
+		start = task.Pomodoros[0].Start.Format(config.DateTimeFmt)
+	}
+	fmt.Printf("%s%d: [%s] [%s] ", indent, task.ID, start, task.Duration.Truncate(time.Second))
+	// a list of green/yellow/magenta/grey/red pomodoros
+	// green indicates the pomodoro was finished normally
+	// yellow indicates the break was exceeded by +5minutes
+	// magenta indicates the pomodoro was interrupted
+	// grey indicates the pomodoro was abandoned
+	// red indicates the pomodoro was never started
+	fmt.Printf("[")
+	for i, pomodoro := range task.Pomodoros {
+		if i > 0 {
+			fmt.Printf(" ")
+		}
+		if pomodoro.Status == PomodoroInterrupted {
+			color.New(color.FgMagenta).Printf("X")
+		} else if pomodoro.Status == PomodoroAbandoned {
+			color.New(color.FgHiBlack).Printf("X")
+		} else if pomodoro.Duration() > task.Duration+5*time.Minute {
 			// pomodoro exceeded it's expected duration by more than 5m
-			if pomodoro.Duration() > task.Duration+5*time.Minute {
-				color.New(color.FgYellow).Printf("X")
-			} else {
-				// pomodoro completed normally
-				color.New(color.FgGreen).Printf("X")
-			}
+			color.New(color.FgYellow).Printf("X")
+		} else {
+			// pomodoro completed normally
+			color.New(color.FgGreen).Printf("X")
 		}
-		// each missed pomodoro
-		for i := 0; i < task.NPomodoros-len(task.Pomodoros); i++ {
-			if i > 0 || i == 0 && len(task.Pomodoros) > 0 {
+	}
+	// each pomodoro still to be completed, counted as
+	// the session counts them when the task is resumed
+	for i := 0; i < task.NPomodoros-task.Completed(); i++ {
+		if i > 0 || len(task.Pomodoros) > 0 {
+			fmt.Printf(" ")
+		}
+		color.New(color.FgRed).Printf("X")
+	}
+	fmt.Printf("]")
+	// Tags
+	if len(task.Tags) > 0 {
+		fmt.Printf(" [")
+		for i, tag := range task.Tags {
+			if i > 0 && i != len(task.Tags) {
 				fmt.Printf(" ")
 			}
-			color.New(color.FgRed).Printf("X")
-		}
-		fmt.Printf("]")
-		// Tags
-		if len(task.Tags) > 0 {
-			fmt.Printf(" [")
-			for i, tag := range task.Tags {
-				if i > 0 && i != len(task.Tags) {
-					fmt.Printf(" ")
-				}
-				// user specified color mapping exists
-				if config.Colors != nil {
-					if color := config.Colors.Get(tag); color != nil {
-						color.Printf("%s", tag)
-					} else {
-						// no color mapping for tag
-						fmt.Printf("%s", tag)
-					}
+			// user specified color mapping exists
+			if config.Colors != nil {
+				if color := config.Colors.Get(tag); color != nil {
+					color.Printf("%s", tag)
 				} else {
-					// no color mapping
+					// no color mapping for tag
 					fmt.Printf("%s", tag)
 				}
-
+			} else {
+				// no color mapping
+				fmt.Printf("%s", tag)
 			}
-			fmt.Printf("]")
+
 		}
-		fmt.Printf(" - %s", task.Message)
-		fmt.Printf("\n")
+		fmt.Printf("]")
+	}
+	if task.Project != "" {
+		fmt.Printf(" @%s", task.Project)
 	}
+	fmt.Printf(" - %s%s", task.Message, suffix)
+	fmt.Printf("\n")
 }
 
 func FormatStatus(status Status) string {
@@ -72,7 +102,7 @@ func FormatStatus(status Status) string {
 	if status.State >= RUNNING {
 		state = string(status.State.String()[0])
 	}
-	if status.State == RUNNING {
+	if status.State == RUNNING || status.State == BREAKING {
 		return fmt.Sprintf("%s [%d/%d] %s", state, status.Count, status.NPomodoros, status.Remaining)
 	} else {
 		return fmt.Sprintf("%s [%d/%d] -", state, status.Count, status.NPomodoros)
@@ -0,0 +1,414 @@
+package pomo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWebhookTimeout = 10 * time.Second
+	defaultWebhookRetries = 8
+	// webhookBackoff is the wait before the first retry
+	// of a delivery, it doubles after each attempt
+	webhookBackoff    = 5 * time.Second
+	webhookMaxBackoff = 10 * time.Minute
+	// outboxFlushTimeout bounds how long Stop spends on
+	// deliveries, the rest are sent by the next session
+	outboxFlushTimeout = 2 * time.Second
+)
+
+// Webhook receives session events as JSON POST requests
+type Webhook struct {
+	URL string
+	// Secret signs the body of each request
+	// with HMAC-SHA256 when it is set
+	Secret string
+	// On lists the events sent to the webhook,
+	// all of them are sent if it is empty
+	On []EventType
+	// Timeout is how long a request may take
+	Timeout time.Duration
+	// Retries is how many times a failed
+	// delivery is attempted again
+	Retries int
+}
+
+type webhookJSON struct {
+	URL     string      `json:"url"`
+	Secret  string      `json:"secret,omitempty"`
+	On      []EventType `json:"on"`
+	Timeout string      `json:"timeout"`
+	Retries *int        `json:"retries"`
+}
+
+func (w Webhook) MarshalJSON() ([]byte, error) {
+	return json.Marshal(webhookJSON{
+		URL:     w.URL,
+		Secret:  w.Secret,
+		On:      w.On,
+		Timeout: w.Timeout.String(),
+		Retries: &w.Retries,
+	})
+}
+
+func (w *Webhook) UnmarshalJSON(raw []byte) error {
+	parsed := webhookJSON{}
+	err := json.Unmarshal(raw, &parsed)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(parsed.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("webhook url %q must be http or https", parsed.URL)
+	}
+	err = validateEvents(parsed.On)
+	if err != nil {
+		return err
+	}
+	webhook := Webhook{
+		URL:     parsed.URL,
+		Secret:  parsed.Secret,
+		On:      parsed.On,
+		Timeout: defaultWebhookTimeout,
+		Retries: defaultWebhookRetries,
+	}
+	if parsed.Timeout != "" {
+		webhook.Timeout, err = time.ParseDuration(parsed.Timeout)
+		if err != nil {
+			return err
+		}
+	}
+	if parsed.Retries != nil {
+		webhook.Retries = *parsed.Retries
+	}
+	*w = webhook
+	return nil
+}
+
+// Matches reports whether the event type is sent to the webhook
+func (w Webhook) Matches(eventType EventType) bool {
+	return matchesEvent(w.On, eventType)
+}
+
+// WebhookPayload is the body POSTed to webhooks, the
+// ID is the same for every attempt of a delivery.
+type WebhookPayload struct {
+	ID string `json:"id"`
+	HookPayload
+}
+
+// SignWebhook returns the X-Pomo-Signature header
+// of a request with the given body.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// delivery is a payload waiting in the outbox
+type delivery struct {
+	ID string `json:"id"`
+	// Webhook is the index of the webhook in the config
+	Webhook  int       `json:"webhook"`
+	URL      string    `json:"url"`
+	Event    EventType `json:"event"`
+	Body     []byte    `json:"body"`
+	Attempts int       `json:"attempts"`
+	Next     time.Time `json:"next"`
+}
+
+type webhookStatusError int
+
+func (e webhookStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", int(e), http.StatusText(int(e)))
+}
+
+// permanent reports whether retrying cannot succeed
+func (e webhookStatusError) permanent() bool {
+	return e >= 400 && e < 500 && e != http.StatusRequestTimeout && e != http.StatusTooManyRequests
+}
+
+// Outbox delivers session events to webhooks, each event is
+// written to a directory before it is sent so deliveries
+// which have not succeeded yet survive a restart. Deliveries
+// to a webhook are sent in the order they were queued.
+type Outbox struct {
+	dir      string
+	webhooks []Webhook
+	clock    Clock
+
+	mu  sync.Mutex
+	seq int
+	// delivering is held while sending so Enqueue
+	// is not held up by a slow webhook
+	delivering sync.Mutex
+	wake       chan struct{}
+	stop       chan struct{}
+	stopped    chan struct{}
+	// ctx is canceled once Stop runs out of time
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewOutbox(dir string, webhooks []Webhook, clock Clock) (*Outbox, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Outbox{
+		dir:      dir,
+		webhooks: webhooks,
+		clock:    clock,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// Matches reports whether the event type is sent to any webhook
+func (o *Outbox) Matches(eventType EventType) bool {
+	for _, webhook := range o.webhooks {
+		if webhook.Matches(eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Enqueue writes the payload to the outbox once
+// for each webhook its event is sent to.
+func (o *Outbox) Enqueue(payload HookPayload) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := o.clock.Now()
+	for i, webhook := range o.webhooks {
+		if !webhook.Matches(payload.Event.Type) {
+			continue
+		}
+		o.seq++
+		// IDs sort in the order deliveries were queued
+		id := fmt.Sprintf("%019d-%06d", now.UnixNano(), o.seq)
+		body, err := json.Marshal(WebhookPayload{ID: id, HookPayload: payload})
+		if err != nil {
+			return err
+		}
+		err = o.write(delivery{
+			ID:      id,
+			Webhook: i,
+			URL:     webhook.URL,
+			Event:   payload.Event.Type,
+			Body:    body,
+			Next:    now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// write replaces the delivery file atomically
+func (o *Outbox) write(d delivery) error {
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(o.dir, d.ID+".tmp")
+	err = ioutil.WriteFile(tmp, raw, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path.Join(o.dir, d.ID+".json"))
+}
+
+// webhook returns the index of the webhook a delivery is for,
+// the URL is checked in case the config changed since it was
+// queued and deliveries of older versions are matched by it.
+func (o *Outbox) webhook(d delivery) (int, bool) {
+	if d.Webhook >= 0 && d.Webhook < len(o.webhooks) && o.webhooks[d.Webhook].URL == d.URL {
+		return d.Webhook, true
+	}
+	for i, webhook := range o.webhooks {
+		if webhook.URL == d.URL {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Deliver sends each delivery which is due and returns when
+// the next one will be or the zero time if there are none.
+// Failed deliveries are retried with exponential backoff
+// until they run out of retries, then they are dropped.
+func (o *Outbox) Deliver() (time.Time, error) {
+	o.delivering.Lock()
+	defer o.delivering.Unlock()
+	files, err := ioutil.ReadDir(o.dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var next time.Time
+	// webhooks with an earlier delivery still pending
+	waiting := map[int]bool{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		filePath := path.Join(o.dir, file.Name())
+		d := delivery{}
+		raw, err := ioutil.ReadFile(filePath)
+		if err == nil {
+			err = json.Unmarshal(raw, &d)
+		}
+		if err != nil {
+			log.Printf("dropping unreadable webhook delivery %s: %s", file.Name(), err)
+			os.Remove(filePath)
+			continue
+		}
+		index, ok := o.webhook(d)
+		if !ok {
+			log.Printf("dropping webhook delivery %s, %s is no longer configured", d.ID, d.URL)
+			os.Remove(filePath)
+			continue
+		}
+		if waiting[index] {
+			continue
+		}
+		webhook := o.webhooks[index]
+		now := o.clock.Now()
+		if d.Next.After(now) {
+			waiting[index] = true
+			next = earliest(next, d.Next)
+			continue
+		}
+		if o.ctx.Err() != nil {
+			// stopped, what is left is kept for next time
+			return next, nil
+		}
+		err = send(o.ctx, webhook, d)
+		if err == nil {
+			os.Remove(filePath)
+			continue
+		}
+		if o.ctx.Err() != nil {
+			// cut short by Stop, it does not count as an attempt
+			return next, nil
+		}
+		d.Attempts++
+		if status, ok := err.(webhookStatusError); (ok && status.permanent()) || d.Attempts > webhook.Retries {
+			log.Printf("dropping webhook delivery %s to %s after %d attempts: %s", d.ID, d.URL, d.Attempts, err)
+			os.Remove(filePath)
+			continue
+		}
+		backoff := webhookBackoff << uint(d.Attempts-1)
+		if backoff > webhookMaxBackoff || backoff <= 0 {
+			backoff = webhookMaxBackoff
+		}
+		d.Next = now.Add(backoff)
+		log.Printf("webhook delivery %s to %s failed, retrying in %s: %s", d.ID, d.URL, backoff, err)
+		err = o.write(d)
+		if err != nil {
+			return next, err
+		}
+		waiting[index] = true
+		next = earliest(next, d.Next)
+	}
+	return next, nil
+}
+
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func send(ctx context.Context, webhook Webhook, d delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pomo/"+Version)
+	req.Header.Set("X-Pomo-Event", string(d.Event))
+	req.Header.Set("X-Pomo-Delivery", d.ID)
+	if webhook.Secret != "" {
+		req.Header.Set("X-Pomo-Signature", SignWebhook(webhook.Secret, d.Body))
+	}
+	client := &http.Client{Timeout: webhook.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return webhookStatusError(resp.StatusCode)
+	}
+	return nil
+}
+
+// Start delivers the outbox in the background until Stop is called
+func (o *Outbox) Start() {
+	go func() {
+		defer close(o.stopped)
+		timer := o.clock.NewTimer(time.Hour)
+		defer timer.Stop()
+		for {
+			next, err := o.Deliver()
+			if err != nil {
+				log.Printf("failed to deliver webhooks: %s", err)
+			}
+			timer.Stop()
+			if !next.IsZero() {
+				timer.Reset(next.Sub(o.clock.Now()))
+			}
+			select {
+			case <-o.wake:
+			case <-timer.C():
+			case <-o.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends background delivery after attempting anything still
+// due for up to outboxFlushTimeout, deliveries which fail or are
+// cut short are kept for next time.
+func (o *Outbox) Stop() {
+	timer := time.AfterFunc(outboxFlushTimeout, o.cancel)
+	defer timer.Stop()
+	defer o.cancel()
+	close(o.stop)
+	<-o.stopped
+	_, err := o.Deliver()
+	if err != nil {
+		log.Printf("failed to deliver webhooks: %s", err)
+	}
+}
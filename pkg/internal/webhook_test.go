@@ -0,0 +1,277 @@
+package pomo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookRequest struct {
+	header  http.Header
+	payload WebhookPayload
+	body    []byte
+}
+
+// webhookServer records requests, the first
+// failures requests are answered with status.
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []webhookRequest
+	failures int
+	status   int
+}
+
+func newWebhookServer(t *testing.T) *webhookServer {
+	server := &webhookServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		if server.failures > 0 {
+			server.failures--
+			w.WriteHeader(server.status)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		request := webhookRequest{header: r.Header, body: body}
+		if err := json.Unmarshal(body, &request.payload); err != nil {
+			t.Error(err)
+		}
+		server.requests = append(server.requests, request)
+	}))
+	return server
+}
+
+func (s *webhookServer) fail(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = n
+	s.status = status
+}
+
+func (s *webhookServer) received() []webhookRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]webhookRequest{}, s.requests...)
+}
+
+func countDeliveries(t *testing.T, dir string) int {
+	t.Helper()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestWebhookConfig(t *testing.T) {
+	config := &Config{}
+	err := json.Unmarshal([]byte(`{"webhooks": [
+		{"url": "http://localhost:8080/pomo", "secret": "s3cret", "on": ["session_complete"]},
+		{"url": "https://example.com/hook", "timeout": "1s", "retries": 0}
+	]}`), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := config.Webhooks[0], config.Webhooks[1]
+	if first.Timeout != defaultWebhookTimeout || first.Retries != defaultWebhookRetries || first.Matches(EventPaused) {
+		t.Fatalf("unexpected webhook %+v", first)
+	}
+	if second.Timeout != time.Second || second.Retries != 0 || !second.Matches(EventPaused) {
+		t.Fatalf("unexpected webhook %+v", second)
+	}
+	raw, err := json.Marshal(config.Redacted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "s3cret") || !strings.Contains(string(raw), redactedSecret) {
+		t.Fatalf("expected the secret to be redacted, got %s", raw)
+	}
+	if config.Webhooks[0].Secret != "s3cret" {
+		t.Fatal("redacting changed the config")
+	}
+	for _, raw := range []string{
+		`{"webhooks": [{"url": "ftp://example.com"}]}`,
+		`{"webhooks": [{"url": "http://example.com", "on": ["tick"]}]}`,
+	} {
+		if err := json.Unmarshal([]byte(raw), &Config{}); err == nil {
+			t.Fatalf("expected an error for %s", raw)
+		}
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	server := newWebhookServer(t)
+	defer server.Close()
+	dir, _ := ioutil.TempDir("/tmp", "")
+	defer os.RemoveAll(dir)
+	clock := NewFakeClock(time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC))
+	webhooks := []Webhook{{URL: server.URL, Secret: "s3cret", Timeout: time.Second, Retries: 2}}
+	outbox, err := NewOutbox(dir, webhooks, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := &Task{ID: 1, Message: "Test Task"}
+	for _, eventType := range []EventType{EventPomodoroStarted, EventPaused} {
+		err := outbox.Enqueue(HookPayload{Event: Event{Type: eventType, Time: clock.Now()}, Task: task})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.fail(1, http.StatusServiceUnavailable)
+	next, err := outbox.Deliver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// later deliveries wait for the failed one to be retried
+	if !next.Equal(clock.Now().Add(webhookBackoff)) || len(server.received()) != 0 {
+		t.Fatalf("expected a retry in %s, got %s", webhookBackoff, next)
+	}
+
+	// pending deliveries survive a restart
+	outbox, err = NewOutbox(dir, webhooks, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(webhookBackoff)
+	next, err = outbox.Deliver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := server.received()
+	if !next.IsZero() || len(received) != 2 || countDeliveries(t, dir) != 0 {
+		t.Fatalf("expected 2 deliveries, got %d", len(received))
+	}
+	for i, eventType := range []EventType{EventPomodoroStarted, EventPaused} {
+		request := received[i]
+		if request.payload.Event.Type != eventType || request.header.Get("X-Pomo-Event") != string(eventType) {
+			t.Fatalf("expected %s, got %s", eventType, request.payload.Event.Type)
+		}
+		if request.payload.Task.Message != "Test Task" || request.header.Get("X-Pomo-Delivery") != request.payload.ID {
+			t.Fatalf("unexpected payload %s", request.body)
+		}
+		if request.header.Get("X-Pomo-Signature") != SignWebhook("s3cret", request.body) {
+			t.Fatalf("invalid signature %s", request.header.Get("X-Pomo-Signature"))
+		}
+	}
+
+	// client errors are not retried and neither are deliveries out of retries
+	for _, status := range []int{http.StatusBadRequest, http.StatusInternalServerError} {
+		outbox.Enqueue(HookPayload{Event: Event{Type: EventResumed}})
+		server.fail(3, status)
+		for i := 0; i < 3; i++ {
+			next, err = outbox.Deliver()
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(time.Hour)
+		}
+		if !next.IsZero() || countDeliveries(t, dir) != 0 {
+			t.Fatalf("expected the delivery to be dropped after status %d", status)
+		}
+		server.fail(0, 0)
+	}
+	if len(server.received()) != 2 {
+		t.Fatal("dropped deliveries should not be sent")
+	}
+
+	// webhooks sharing a URL sign with their own secret
+	webhooks = append(webhooks, Webhook{URL: server.URL, Secret: "other", Timeout: time.Second})
+	outbox, err = NewOutbox(dir, webhooks, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox.Enqueue(HookPayload{Event: Event{Type: EventResumed}})
+	if _, err = outbox.Deliver(); err != nil {
+		t.Fatal(err)
+	}
+	received = server.received()[2:]
+	if len(received) != 2 {
+		t.Fatalf("expected a delivery to each webhook, got %d", len(received))
+	}
+	for i, secret := range []string{"s3cret", "other"} {
+		if received[i].header.Get("X-Pomo-Signature") != SignWebhook(secret, received[i].body) {
+			t.Fatalf("expected delivery %d to be signed with %s", i, secret)
+		}
+	}
+}
+
+func TestOutboxStop(t *testing.T) {
+	received := make(chan struct{}, 10)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	dir, _ := ioutil.TempDir("/tmp", "")
+	defer os.RemoveAll(dir)
+	outbox, err := NewOutbox(dir, []Webhook{{URL: server.URL, Timeout: time.Minute, Retries: 0}}, SystemClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox.Start()
+	for i := 0; i < 2; i++ {
+		if err := outbox.Enqueue(HookPayload{Event: Event{Type: EventPaused}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-received
+	// an unreachable webhook does not hold up quitting
+	start := time.Now()
+	outbox.Stop()
+	if elapsed := time.Since(start); elapsed > outboxFlushTimeout+time.Second {
+		t.Fatalf("stopping took %s", elapsed)
+	}
+	// the delivery cut short is not counted as an attempt
+	if countDeliveries(t, dir) != 2 {
+		t.Fatal("expected the deliveries to be kept for next time")
+	}
+}
+
+func TestTaskRunnerWebhooks(t *testing.T) {
+	server := newWebhookServer(t)
+	defer server.Close()
+	runner, store, clock := initTestRunner(t, &Task{
+		Duration:   25 * time.Minute,
+		NPomodoros: 1,
+		Message:    "Test Task",
+	})
+	defer store.Close()
+	dir, _ := ioutil.TempDir("/tmp", "")
+	defer os.RemoveAll(dir)
+	outbox, err := NewOutbox(dir, []Webhook{{
+		URL:     server.URL,
+		On:      []EventType{EventPomodoroStarted, EventPomodoroEnded, EventSessionComplete},
+		Timeout: time.Second,
+	}}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.outbox = outbox
+
+	runner.Start()
+	waitState(t, runner, RUNNING)
+	clock.Advance(25 * time.Minute)
+	<-runner.Done()
+
+	received := server.received()
+	if len(received) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(received))
+	}
+	ended := received[1].payload
+	if ended.Event.Type != EventPomodoroEnded || ended.Event.Pomodoro == nil || ended.Event.Pomodoro.Status != PomodoroCompleted {
+		t.Fatalf("unexpected event %s", received[1].body)
+	}
+	if complete := received[2].payload; complete.Event.Status.State != COMPLETE || complete.Task.State != TaskDone {
+		t.Fatalf("unexpected event %s", received[2].body)
+	}
+}